@@ -0,0 +1,147 @@
+package uri
+
+import (
+	"net/textproto"
+	"reflect"
+	"strings"
+	"sync"
+)
+
+// fieldInfo is the reflection data of a struct field that is needed
+// to unmarshal or marshal it. It is computed once per struct type and cached.
+type fieldInfo struct {
+	index    int
+	sField   reflect.StructField
+	name     string   // param name, from the tag or the field name
+	tag      string   // tag value used to match special keywords
+	param    string   // name of the path template param {name}
+	pos      *pathPos // position of the path[i] segments
+	header   string   // canonical name of the http header
+	def      string
+	required bool
+	embedded bool // struct or *struct handled recursively or by a custom (un)marshaler
+	structs  bool // slice or map of structs with keyed params, items[0].sku
+
+	set    setFunc   // decoding only
+	list   listFunc  // decoding of slices and arrays only
+	pairs  pairsFunc // decoding of maps only
+	check  checkFunc // validation tags, decoding only
+	tagErr error     // invalid validation tag
+	cond   *conditions
+}
+
+// typeCache is a concurrency safe store of fieldInfo for struct types
+type typeCache struct {
+	m sync.Map // map[reflect.Type][]fieldInfo
+}
+
+func (c *typeCache) load(t reflect.Type, build func(reflect.Type) []fieldInfo) []fieldInfo {
+	if f, ok := c.m.Load(t); ok {
+		return f.([]fieldInfo)
+	}
+	f, _ := c.m.LoadOrStore(t, build(t))
+	return f.([]fieldInfo)
+}
+
+func newFieldInfo(o options, i int, sField reflect.StructField) fieldInfo {
+	f := fieldInfo{
+		index:    i,
+		sField:   sField,
+		name:     sField.Name,
+		tag:      o.fieldTag(sField.Tag),
+		def:      sField.Tag.Get(defaultTag),
+		required: sField.Tag.Get(requiredTag) == "true",
+	}
+	if f.tag != "" && f.tag != "-" {
+		f.name = f.tag
+	}
+	f.param = templateParam(f.tag)
+	f.pos = parsePathPos(strings.ToLower(f.tag))
+	if h := sField.Tag.Get(headerTag); h != "" {
+		f.header = textproto.CanonicalMIMEHeaderKey(h)
+	}
+	return f
+}
+
+// fields of struct type t used by the Decoder
+func (d *Decoder) fields(t reflect.Type) []fieldInfo {
+	return d.cache.load(t, func(t reflect.Type) []fieldInfo {
+		fields := make([]fieldInfo, 0, t.NumField())
+		for i := 0; i < t.NumField(); i++ {
+			sField := t.Field(i)
+			if sField.PkgPath != "" { // skip private variables
+				continue
+			}
+			f := newFieldInfo(d.opts, i, sField)
+			if f.tag == "-" {
+				continue
+			}
+			f.tag = strings.ToLower(f.tag)
+			f.embedded = isEmbedded(sField.Type, textUnmarshalerType) || implementsURI(sField.Type, unmarshalerType)
+			f.structs = isStructs(sField.Type, textUnmarshalerType, unmarshalerType)
+			f.set = d.setter(sField.Type, sField)
+			if k := sField.Type.Kind(); k == reflect.Slice || k == reflect.Array {
+				f.list = d.listSetter(sField.Type, sField)
+			}
+			if sField.Type.Kind() == reflect.Map {
+				f.pairs = d.pairsSetter(sField.Type, sField)
+			}
+			f.check, f.tagErr = d.checker(sField)
+			if c, err := parseConditions(sField); err != nil {
+				f.tagErr = err
+			} else {
+				f.cond = c
+			}
+			fields = append(fields, f)
+		}
+		return fields
+	})
+}
+
+// fields of struct type t used by the Encoder
+func (e *Encoder) fields(t reflect.Type) []fieldInfo {
+	return e.cache.load(t, func(t reflect.Type) []fieldInfo {
+		fields := make([]fieldInfo, 0, t.NumField())
+		for i := 0; i < t.NumField(); i++ {
+			sField := t.Field(i)
+			if sField.PkgPath != "" { // skip unexported variables
+				continue
+			}
+			f := newFieldInfo(e.opts, i, sField)
+			f.embedded = isEmbedded(sField.Type, textMarshalerType) || implementsURI(sField.Type, marshalerType)
+			f.structs = isStructs(sField.Type, textMarshalerType, marshalerType)
+			if f.tag == "-" && !f.embedded {
+				continue
+			}
+			fields = append(fields, f)
+		}
+		return fields
+	})
+}
+
+// isStructs reports if t is a slice or map of structs or *structs that are
+// handled field by field because they implement neither of the ifaces
+func isStructs(t reflect.Type, text, uri reflect.Type) bool {
+	k := t.Kind()
+	return (k == reflect.Slice || k == reflect.Map) && isEmbedded(t.Elem(), text) && !implementsURI(t.Elem(), uri)
+}
+
+// isEmbedded reports if t is a struct or *struct that is handled
+// field by field because it does not implement the iface
+func isEmbedded(t reflect.Type, iface reflect.Type) bool {
+	switch t.Kind() {
+	case reflect.Struct:
+		return !reflect.PtrTo(t).Implements(iface)
+	case reflect.Ptr:
+		return t.Elem().Kind() == reflect.Struct && !t.Implements(iface)
+	}
+	return false
+}
+
+// validate the value of the field against its validation tags
+func (f *fieldInfo) validate(value reflect.Value) error {
+	if f.check == nil {
+		return nil
+	}
+	return f.check(value)
+}
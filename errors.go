@@ -0,0 +1,305 @@
+package uri
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// InvalidMarshalError is returned when a value that is not a struct
+// or a pointer to a struct is marshaled.
+type InvalidMarshalError struct {
+	Type reflect.Type
+}
+
+func (e *InvalidMarshalError) Error() string {
+	return fmt.Sprintf("uri: can not marshal %v, must be a struct or pointer to a struct", e.Type)
+}
+
+// UnsupportedTypeError is returned for values that do not have a uri representation.
+type UnsupportedTypeError struct {
+	Type reflect.Type
+}
+
+func (e *UnsupportedTypeError) Error() string {
+	return fmt.Sprintf("uri: unsupported type %v", e.Type)
+}
+
+// MarshalError describes a struct field that could not be marshaled
+type MarshalError struct {
+	Field string       // name of the struct field, empty for a Marshaler
+	Type  reflect.Type // type of the struct field
+	Err   error
+}
+
+func (e *MarshalError) Error() string {
+	if e.Field == "" {
+		return fmt.Sprintf("uri: can not marshal %v: %v", e.Type, e.Err)
+	}
+	return fmt.Sprintf("uri: can not marshal %s (%v): %v", e.Field, e.Type, e.Err)
+}
+
+// Unwrap returns the underlying error
+func (e *MarshalError) Unwrap() error { return e.Err }
+
+// InvalidUnmarshalError is returned when Unmarshal is not given
+// a non nil pointer to a struct.
+type InvalidUnmarshalError struct {
+	Type reflect.Type
+}
+
+func (e *InvalidUnmarshalError) Error() string {
+	return fmt.Sprintf("%v must be a non nil pointer to a struct", e.Type)
+}
+
+// FieldError describes a uri value that could not be set to a struct field
+type FieldError struct {
+	Field  string       // name of the struct field
+	Param  string       // name of the uri param or special keyword
+	Value  string       // value from the uri
+	Type   reflect.Type // type of the struct field
+	Reason string       // why the value could not be set
+	Err    error        // underlying conversion error
+}
+
+func (e *FieldError) Error() string {
+	return fmt.Sprintf("%s: %q %s", e.Param, e.Value, e.Reason)
+}
+
+// Unwrap returns the underlying conversion error
+func (e *FieldError) Unwrap() error { return e.Err }
+
+// elemError identifies the slice index or map key of an element that could not be set
+type elemError struct {
+	key   string
+	value string
+	err   error
+}
+
+func (e *elemError) Error() string {
+	return fmt.Sprintf("[%s] %q: %v", e.key, e.value, e.err)
+}
+
+func (e *elemError) Unwrap() error { return e.err }
+
+// lengthError is returned when the number of elements does not match the length of an array
+type lengthError struct {
+	expected, got int
+}
+
+func (e *lengthError) Error() string {
+	return fmt.Sprintf("expected %d elements got %d", e.expected, e.got)
+}
+
+// fieldErrors converts the error of a setter into FieldErrors.
+// Errors of slice and map elements are reported with the index or key
+// of the element appended to the param, ints[1]: "x" is not an int
+func fieldErrors(f *fieldInfo, param, value string, err error) error {
+	var errs MultiError
+	var walk func(param, value string, err error)
+	walk = func(param, value string, err error) {
+		switch e := err.(type) {
+		case MultiError:
+			for _, err := range e {
+				walk(param, value, err)
+			}
+		case *elemError:
+			walk(param+"["+e.key+"]", e.value, e.err)
+		default:
+			errs.add(&FieldError{
+				Field:  f.sField.Name,
+				Param:  param,
+				Value:  value,
+				Type:   f.sField.Type,
+				Reason: reason(err, f.sField.Type),
+				Err:    err,
+			})
+		}
+	}
+	walk(param, value, err)
+	return errs.errOrNil()
+}
+
+// ValidationError is the Err of a FieldError for a value that was set
+// but does not pass the rule of a validation tag
+type ValidationError struct {
+	Rule  string // name of the tag: min, max, minlen, maxlen, enum or pattern
+	Limit string // value of the tag
+}
+
+func (e *ValidationError) Error() string {
+	switch e.Rule {
+	case minTag:
+		return "must be at least " + e.Limit
+	case maxTag:
+		return "must be at most " + e.Limit
+	case minLenTag:
+		return "must have a length of at least " + e.Limit
+	case maxLenTag:
+		return "must have a length of at most " + e.Limit
+	case enumTag:
+		return "must be one of " + e.Limit
+	}
+	return "must match " + e.Limit
+}
+
+// TagError is returned when the value of a validation tag can not be used with its field
+type TagError struct {
+	Field string // name of the struct field
+	Tag   string // name of the tag
+	Value string // value of the tag
+	Err   error
+}
+
+func (e *TagError) Error() string {
+	return fmt.Sprintf("invalid %s tag %q on %s: %v", e.Tag, e.Value, e.Field, e.Err)
+}
+
+// Unwrap returns the underlying error
+func (e *TagError) Unwrap() error { return e.Err }
+
+// RequiredError is returned when a required param is missing
+type RequiredError struct {
+	Field string // name of the struct field
+	Param string // name of the uri param or special keyword
+	Cond  string // condition of a required_if or required_with tag, "with start"
+}
+
+func (e *RequiredError) Error() string {
+	if e.Cond != "" {
+		return fmt.Sprintf("%s is required %s", e.Param, e.Cond)
+	}
+	return fmt.Sprintf("%s is required", e.Param)
+}
+
+// ExcludedError is returned when a param is combined with a param of its excluded_with tag
+type ExcludedError struct {
+	Field string // name of the struct field
+	Param string // name of the uri param
+	With  string // the param it can not be used with
+}
+
+func (e *ExcludedError) Error() string {
+	return fmt.Sprintf("%s can not be used with %s", e.Param, e.With)
+}
+
+// DefaultError is returned when the value of a default tag can not be set to its field
+type DefaultError struct {
+	Field string       // name of the struct field
+	Param string       // name of the uri param or special keyword
+	Value string       // value of the default tag
+	Type  reflect.Type // type of the struct field
+	Err   error        // underlying conversion error
+}
+
+func (e *DefaultError) Error() string {
+	return fmt.Sprintf("default value %s can not be set to %s (%v)", e.Value, e.Param, e.Type)
+}
+
+// Unwrap returns the underlying conversion error
+func (e *DefaultError) Unwrap() error { return e.Err }
+
+// UnknownParamError is returned for a query param that does not map to a struct field
+// when the Decoder is created with DisallowUnknownParams.
+type UnknownParamError struct {
+	Param string
+}
+
+func (e *UnknownParamError) Error() string {
+	return fmt.Sprintf("unknown param %s", e.Param)
+}
+
+// MultiError is a list of errors found while unmarshaling.
+// errors.Is and errors.As check each of the errors in the list.
+type MultiError []error
+
+func (m MultiError) Error() string {
+	s := make([]string, len(m))
+	for i, err := range m {
+		s[i] = err.Error()
+	}
+	return strings.Join(s, "\n")
+}
+
+// Is reports if any of the errors match target
+func (m MultiError) Is(target error) bool {
+	for _, err := range m {
+		if errors.Is(err, target) {
+			return true
+		}
+	}
+	return false
+}
+
+// As finds the first error that matches target
+func (m MultiError) As(target interface{}) bool {
+	for _, err := range m {
+		if errors.As(err, target) {
+			return true
+		}
+	}
+	return false
+}
+
+// Unwrap returns the list of errors
+func (m MultiError) Unwrap() []error { return m }
+
+// add an error to the list, nested MultiErrors are flattened and nil errors are ignored
+func (m *MultiError) add(err error) {
+	if err == nil {
+		return
+	}
+	if errs, ok := err.(MultiError); ok {
+		*m = append(*m, errs...)
+		return
+	}
+	*m = append(*m, err)
+}
+
+func (m MultiError) errOrNil() error {
+	if len(m) == 0 {
+		return nil
+	}
+	return m
+}
+
+// reason describes why value could not be converted to type t
+func reason(err error, t reflect.Type) string {
+	switch e := err.(type) {
+	case *strconv.NumError:
+		kind := strings.TrimPrefix(e.Func, "Parse")
+		kind = strings.ToLower(kind)
+		if e.Err == strconv.ErrRange {
+			return "is out of range for " + kind
+		}
+		if strings.HasPrefix(kind, "i") {
+			return "is not an " + kind
+		}
+		return "is not a " + kind
+	case *time.ParseError:
+		return fmt.Sprintf("does not match time format %q", e.Layout)
+	case *ValidationError:
+		return e.Error()
+	case *lengthError:
+		return fmt.Sprintf("has %d elements, %v requires %d", e.got, t, e.expected)
+	}
+	return fmt.Sprintf("can not be set to %v: %v", t, err)
+}
+
+// TemplateError is returned when the path of a uri does not match the
+// template registered for the struct, see RegisterTemplate
+type TemplateError struct {
+	Template string
+	Path     string
+	Param    string // the named segment with an invalid value
+}
+
+func (e *TemplateError) Error() string {
+	if e.Param != "" {
+		return fmt.Sprintf("path %s does not match template %s: invalid %s", e.Path, e.Template, e.Param)
+	}
+	return fmt.Sprintf("path %s does not match template %s", e.Path, e.Template)
+}
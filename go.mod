@@ -1,9 +1,7 @@
 module github.com/jbsmith7741/uri
 
-go 1.13
+go 1.18
 
-require (
-	github.com/jbsmith7741/go-tools v0.2.0
-	github.com/jbsmith7741/trial v0.3.1
-	github.com/pkg/errors v0.8.1 // indirect
-)
+require github.com/jbsmith7741/trial v0.3.1
+
+require github.com/google/go-cmp v0.4.1 // indirect
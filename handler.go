@@ -0,0 +1,125 @@
+package uri
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// Handler adapts fn to an http.Handler. The request is decoded into T with
+// UnmarshalRequest and the result of fn is written as json.
+//
+//   - 400 - the request could not be decoded, the body lists each invalid param
+//   - 500 - fn returned an error, its message is not written
+//   - 204 - fn returned a nil result
+//
+// fn can return an *HTTPError to choose the status and message of the response.
+// T may be a struct or a pointer to a struct, any other type is a 500.
+func Handler[T any](fn func(ctx context.Context, req T) (any, error)) http.Handler {
+	return HandlerWith(defaultDecoder, fn)
+}
+
+// HandlerWith is the same as Handler but decodes the request with d.
+func HandlerWith[T any](d *Decoder, fn func(ctx context.Context, req T) (any, error)) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		req, target := newTarget[T]()
+		if err := d.UnmarshalRequest(r, target); err != nil {
+			var invalid *InvalidUnmarshalError
+			if errors.As(err, &invalid) {
+				// T is not a struct, the request is not at fault
+				writeJSON(w, http.StatusInternalServerError, internalError)
+				return
+			}
+			writeJSON(w, http.StatusBadRequest, newErrorResponse(err))
+			return
+		}
+
+		result, err := fn(r.Context(), *req)
+		if err != nil {
+			var hErr *HTTPError
+			if errors.As(err, &hErr) {
+				writeJSON(w, hErr.Status, errorResponse{Error: hErr.Message})
+				return
+			}
+			writeJSON(w, http.StatusInternalServerError, internalError)
+			return
+		}
+		if result == nil {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		writeJSON(w, http.StatusOK, result)
+	})
+}
+
+// HTTPError is returned by the fn of a Handler to respond with
+// a status and message other than the generic 500 error.
+//
+//	return nil, &uri.HTTPError{Status: http.StatusNotFound, Message: "order not found"}
+type HTTPError struct {
+	Status  int    // http status code of the response
+	Message string // written as the error of the json body
+}
+
+func (e *HTTPError) Error() string {
+	return fmt.Sprintf("%d %s", e.Status, e.Message)
+}
+
+// internalError hides the cause of a 500 from the client
+var internalError = errorResponse{Error: http.StatusText(http.StatusInternalServerError)}
+
+// errorResponse is the json body written for a failed request
+type errorResponse struct {
+	Error  string       `json:"error"`
+	Params []paramError `json:"params,omitempty"`
+}
+
+// paramError describes an invalid param of the request
+type paramError struct {
+	Param  string `json:"param"`
+	Value  string `json:"value,omitempty"`
+	Reason string `json:"reason"`
+}
+
+func newErrorResponse(err error) errorResponse {
+	var errs MultiError
+	if !errors.As(err, &errs) {
+		return errorResponse{Error: err.Error()}
+	}
+	resp := errorResponse{Error: "invalid request"}
+	for _, err := range errs {
+		var p paramError
+		switch e := err.(type) {
+		case *FieldError:
+			p = paramError{Param: e.Param, Value: e.Value, Reason: e.Reason}
+		case *RequiredError:
+			p = paramError{Param: e.Param, Reason: strings.TrimSpace("is required " + e.Cond)}
+		case *ExcludedError:
+			p = paramError{Param: e.Param, Reason: "can not be used with " + e.With}
+		case *DefaultError:
+			p = paramError{Param: e.Param, Value: e.Value, Reason: "invalid default value"}
+		case *UnknownParamError:
+			p = paramError{Param: e.Param, Reason: "is unknown"}
+		case *TemplateError:
+			p = paramError{Param: e.Param, Value: e.Path, Reason: "does not match " + e.Template}
+		default:
+			p = paramError{Reason: err.Error()}
+		}
+		resp.Params = append(resp.Params, p)
+	}
+	return resp
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(b)
+}
@@ -0,0 +1,85 @@
+package uri
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/jbsmith7741/trial"
+)
+
+type searchRequest struct {
+	Query string `uri:"q" required:"true"`
+	Limit int    `uri:"limit" default:"10"`
+}
+
+func search(_ context.Context, req searchRequest) (any, error) {
+	switch req.Query {
+	case "fail":
+		return nil, errors.New("search failed")
+	case "missing":
+		return nil, fmt.Errorf("search: %w", &HTTPError{Status: http.StatusNotFound, Message: "no results"})
+	case "none":
+		return nil, nil
+	}
+	return map[string]interface{}{"query": req.Query, "limit": req.Limit}, nil
+}
+
+func TestHandler(t *testing.T) {
+	type response struct {
+		Status int
+		Body   string
+	}
+	fn := func(args ...interface{}) (interface{}, error) {
+		h := args[0].(http.Handler)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, args[1].(string), nil))
+		return response{Status: w.Code, Body: strings.TrimSpace(w.Body.String())}, nil
+	}
+	h := Handler(search)
+	cases := trial.Cases{
+		"ok": {
+			Input:    trial.Args(h, "/search?q=go"),
+			Expected: response{Status: 200, Body: `{"limit":10,"query":"go"}`},
+		},
+		"invalid params": {
+			Input: trial.Args(h, "/search?limit=ten"),
+			Expected: response{Status: 400, Body: `{"error":"invalid request","params":[` +
+				`{"param":"q","reason":"is required"},` +
+				`{"param":"limit","value":"ten","reason":"is not an int"}]}`},
+		},
+		"handler error": {
+			Input:    trial.Args(h, "/search?q=fail"),
+			Expected: response{Status: 500, Body: `{"error":"Internal Server Error"}`},
+		},
+		"http error": {
+			Input:    trial.Args(h, "/search?q=missing"),
+			Expected: response{Status: 404, Body: `{"error":"no results"}`},
+		},
+		"invalid request type": {
+			Input: trial.Args(Handler(func(_ context.Context, req int) (any, error) {
+				return req, nil
+			}), "/search?q=go"),
+			Expected: response{Status: 500, Body: `{"error":"Internal Server Error"}`},
+		},
+		"no content": {
+			Input:    trial.Args(h, "/search?q=none"),
+			Expected: response{Status: 204},
+		},
+		"pointer request": {
+			Input: trial.Args(Handler(func(_ context.Context, req *searchRequest) (any, error) {
+				return req, nil
+			}), "/search?q=go"),
+			Expected: response{Status: 200, Body: `{"Query":"go","Limit":10}`},
+		},
+		"decoder options": {
+			Input:    trial.Args(HandlerWith(NewDecoder(DisallowUnknownParams()), search), "/search?q=go&limt=5"),
+			Expected: response{Status: 400, Body: `{"error":"invalid request","params":[{"param":"limt","reason":"is unknown"}]}`},
+		},
+	}
+	trial.New(fn, cases).SubTest(t)
+}
@@ -0,0 +1,53 @@
+package uri
+
+import "reflect"
+
+// BeforeUnmarshaler is implemented by structs that set computed defaults
+// before their fields are decoded.
+type BeforeUnmarshaler interface {
+	BeforeUnmarshalURI()
+}
+
+// AfterUnmarshaler is implemented by structs that need to be finalized
+// after their fields and defaults are set.
+type AfterUnmarshaler interface {
+	AfterUnmarshalURI() error
+}
+
+// Validator is implemented by structs that check rules between fields,
+// such as start < end. It is called after AfterUnmarshalURI.
+type Validator interface {
+	Validate() error
+}
+
+var (
+	beforeUnmarshalerType = reflect.TypeOf((*BeforeUnmarshaler)(nil)).Elem()
+	afterUnmarshalerType  = reflect.TypeOf((*AfterUnmarshaler)(nil)).Elem()
+	validatorType         = reflect.TypeOf((*Validator)(nil)).Elem()
+)
+
+// hasHooks reports if struct type t implements any of the unmarshal hooks
+func hasHooks(t reflect.Type) bool {
+	p := reflect.PtrTo(t)
+	return p.Implements(beforeUnmarshalerType) || p.Implements(afterUnmarshalerType) || p.Implements(validatorType)
+}
+
+// beforeUnmarshal calls the BeforeUnmarshalURI hook of the addressable struct v
+func beforeUnmarshal(v reflect.Value) {
+	if h, ok := v.Addr().Interface().(BeforeUnmarshaler); ok {
+		h.BeforeUnmarshalURI()
+	}
+}
+
+// afterUnmarshal calls the AfterUnmarshalURI and Validate hooks of the addressable struct v
+func afterUnmarshal(v reflect.Value) error {
+	var errs MultiError
+	p := v.Addr().Interface()
+	if h, ok := p.(AfterUnmarshaler); ok {
+		errs.add(h.AfterUnmarshalURI())
+	}
+	if h, ok := p.(Validator); ok {
+		errs.add(h.Validate())
+	}
+	return errs.errOrNil()
+}
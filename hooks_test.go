@@ -0,0 +1,78 @@
+package uri
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/jbsmith7741/trial"
+)
+
+type timeRange struct {
+	Start time.Time `uri:"start" format:"2006-01-02"`
+	End   time.Time `uri:"end" format:"2006-01-02"`
+	Days  int       `uri:"-"`
+}
+
+func (r *timeRange) AfterUnmarshalURI() error {
+	r.Days = int(r.End.Sub(r.Start).Hours() / 24)
+	return nil
+}
+
+func (r *timeRange) Validate() error {
+	if r.End.Before(r.Start) {
+		return errors.New("start must be before end")
+	}
+	return nil
+}
+
+type report struct {
+	Range  *timeRange
+	Limit  int    `uri:"limit"`
+	Format string `uri:"format"`
+}
+
+func (r *report) BeforeUnmarshalURI() {
+	r.Limit = 100
+}
+
+func (r *report) Validate() error {
+	if r.Format != "" && r.Format != "csv" && r.Format != "json" {
+		return errors.New("format must be csv or json")
+	}
+	return nil
+}
+
+func TestHooks(t *testing.T) {
+	fn := func(args ...interface{}) (interface{}, error) {
+		v := &report{}
+		err := Unmarshal(args[0].(string), v)
+		return v, err
+	}
+	cases := trial.Cases{
+		"before": {
+			Input:    "?format=csv",
+			Expected: &report{Limit: 100, Format: "csv"},
+		},
+		"nested": {
+			Input: "?start=2020-01-01&end=2020-01-31&limit=10",
+			Expected: &report{
+				Range: &timeRange{Start: trial.TimeDay("2020-01-01"), End: trial.TimeDay("2020-01-31"), Days: 30},
+				Limit: 10,
+			},
+		},
+		"nested validate": {
+			Input:       "?start=2020-02-01&end=2020-01-01",
+			ExpectedErr: errors.New("start must be before end"),
+		},
+		"validate": {
+			Input:       "?format=xml",
+			ExpectedErr: errors.New("format must be csv or json"),
+		},
+		"merged errors": {
+			Input:       "?limit=x&format=xml",
+			ExpectedErr: errors.New("limit: \"x\" is not an int\nformat must be csv or json"),
+		},
+	}
+	trial.New(fn, cases).SubTest(t)
+}
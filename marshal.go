@@ -4,8 +4,10 @@ import (
 	"fmt"
 	"log"
 	"net/url"
+	"path/filepath"
 	"reflect"
 	"sort"
+	"strconv"
 	"strings"
 	"time"
 )
@@ -14,31 +16,155 @@ const (
 	// sliceDelim used for slices
 	sliceDelim = ","
 	mapDelim   = "|"
+	keySep     = ":"
 
 	// supported struct tags
 	uriTag      = "uri"
 	jsonTag     = "json"
 	defaultTag  = "default"
 	requiredTag = "required"
+	headerTag   = "header"
 
 	// supported tag values
 	scheme    = "scheme"
 	host      = "host"
+	hostname  = "hostname" // host without the port
+	port      = "port"
 	path      = "path"
 	userinfo  = "userinfo"
 	password  = "password"
 	username  = "username"
 	filename  = "filename"
+	dir       = "dir"       // path before the filename
 	authority = "authority" // userinfo@host
 	origin    = "origin"    // scheme://host/path - see https://developer.mozilla.org/en-US/docs/Web/HTTP/Headers/Origin
 	fragment  = "fragment"  // anything after hash #
 )
 
+// Encoder marshals structs into uris using its configured options.
+// An Encoder is safe for concurrent use.
+type Encoder struct {
+	opts  options
+	cache typeCache
+}
+
+// NewEncoder creates an Encoder with the provided options.
+// Without options the Encoder behaves the same as Marshal.
+func NewEncoder(opts ...Option) *Encoder {
+	return &Encoder{opts: newOptions(opts)}
+}
+
+// Marshaler is implemented by structs that encode themselves into a url.
+// It is called instead of encoding the struct field by field. The scheme, host,
+// path, userinfo and fragment of the returned url replace the current values when set
+// and its query values are added to the query.
+type Marshaler interface {
+	MarshalURI() (*url.URL, error)
+}
+
+var (
+	defaultEncoder   = NewEncoder()
+	unescapedEncoder = NewEncoder(Escape(false))
+)
+
 // Marshal a struct into a string representation of a uri
 // Note: Marshal panics if a struct or pointer to a struct is not provided
+// and fields that can not be marshaled are skipped, see MarshalE
 func Marshal(v interface{}) (s string) {
-	u := &url.URL{}
-	uVal := &url.Values{}
+	return defaultEncoder.Marshal(v)
+}
+
+// MarshalUnescaped is the same as marshal but without url encoding the values
+func MarshalUnescaped(v interface{}) string {
+	return unescapedEncoder.Marshal(v)
+}
+
+// GetFieldString returns a string representation of a Value
+// booleans become true/false
+// nil pointers return "nil"
+// slices and arrays combine elements with a comma. []int{1,2,3} -> "1,2,3"
+func GetFieldString(value reflect.Value, sTag reflect.StructTag) string {
+	return defaultEncoder.GetFieldString(value, sTag)
+}
+
+// MarshalE is the same as Marshal but returns an error instead of panicking
+// on invalid input, and reports fields that could not be marshaled.
+func MarshalE(v interface{}) (string, error) {
+	return defaultEncoder.MarshalE(v)
+}
+
+// MarshalURL returns the url for a struct, so it can be modified or merged
+// into an existing url without parsing a string. See MarshalE
+func MarshalURL(v interface{}) (*url.URL, error) {
+	return defaultEncoder.MarshalURL(v)
+}
+
+// MarshalValues returns the query params of a struct, so they can be
+// used as the body of a form post. Special keywords (scheme, host, path, etc) are not included.
+// See MarshalE
+func MarshalValues(v interface{}) (url.Values, error) {
+	return defaultEncoder.MarshalValues(v)
+}
+
+// Marshal a struct into a string representation of a uri
+// using the options of the Encoder. See Marshal
+func (e *Encoder) Marshal(v interface{}) (s string) {
+	u, err := e.marshal(v)
+	if u == nil {
+		panic(err)
+	}
+	s, err = e.format(u)
+	if err != nil {
+		log.Println(err)
+		return u.String()
+	}
+	return s
+}
+
+// MarshalE a struct into a string representation of a uri
+// using the options of the Encoder. See MarshalE
+func (e *Encoder) MarshalE(v interface{}) (string, error) {
+	u, err := e.marshal(v)
+	if err != nil {
+		return "", err
+	}
+	return e.format(u)
+}
+
+// MarshalURL returns the url for a struct
+// using the options of the Encoder. See MarshalURL
+func (e *Encoder) MarshalURL(v interface{}) (*url.URL, error) {
+	u, err := e.marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	return u, nil
+}
+
+// MarshalValues returns the query params of a struct
+// using the options of the Encoder. See MarshalValues
+func (e *Encoder) MarshalValues(v interface{}) (url.Values, error) {
+	s, err := e.encode(v)
+	if err != nil {
+		return nil, err
+	}
+	return s.values, nil
+}
+
+// marshal builds the url for v. The url is returned with the first error
+// found unless v is not a struct.
+func (e *Encoder) marshal(v interface{}) (*url.URL, error) {
+	s, err := e.encode(v)
+	if s == nil {
+		return nil, err
+	}
+	// Note: url values are sorted by string value as they are encoded
+	s.u.RawQuery = s.values.Encode()
+	return s.u, err
+}
+
+// encode the fields of v into the url and query values of the state.
+func (e *Encoder) encode(v interface{}) (*encodeState, error) {
 	vStruct := reflect.ValueOf(v)
 	if vStruct.Kind() == reflect.Ptr {
 		if vStruct.IsNil() {
@@ -46,64 +172,213 @@ func Marshal(v interface{}) (s string) {
 		}
 		vStruct = vStruct.Elem()
 	}
+	if vStruct.Kind() != reflect.Struct {
+		return nil, &InvalidMarshalError{Type: reflect.TypeOf(v)}
+	}
+
+	s := &encodeState{u: &url.URL{}, values: url.Values{}, params: make(map[string]string)}
+	err := e.parseStruct(s, vStruct)
+	if s.hostname != nil || s.port != nil {
+		s.u.Host = s.joinHost()
+	}
+	if len(s.pos) > 0 {
+		var pErr error
+		s.u.Path, pErr = buildPath(s.u.Path, s.pos)
+		if err == nil {
+			err = pErr
+		}
+	}
+	if tmpl := lookupTemplate(vStruct.Type()); tmpl != nil {
+		tmpl.fill(s.u, s.params)
+	}
+	return s, err
+}
 
-	parseStruct(u, uVal, vStruct)
+// encodeState holds the url and values while a struct is marshaled
+type encodeState struct {
+	u      *url.URL
+	values url.Values
+	params map[string]string // named segments of the path template
+	pos    []posValue        // positional path segments
+	prefix string            // key of the nested struct, see NestedPrefix
 
-	// Note: url values are sorted by string value as they are encoded
-	u.RawQuery = uVal.Encode()
-	return u.String()
+	hostname, port *string // set separately from the host
 }
 
-// MarshalUnescaped is the same as marshal but without url encoding the values
-func MarshalUnescaped(v interface{}) string {
-	m := Marshal(v)
-	s, err := url.QueryUnescape(m)
-	if err != nil {
-		log.Println(err)
-		return m
+// joinHost combines the hostname and port with the host of the url.
+// IPv6 literals are wrapped in brackets and zero ports are dropped.
+func (s *encodeState) joinHost() string {
+	h := &url.URL{Host: s.u.Host}
+	hostname, port := h.Hostname(), h.Port()
+	if s.hostname != nil {
+		hostname = *s.hostname
 	}
-	return s
+	if s.port != nil {
+		port = *s.port
+	}
+	if strings.Contains(hostname, ":") {
+		hostname = "[" + hostname + "]"
+	}
+	if port == "" || port == "0" {
+		return hostname
+	}
+	return hostname + ":" + port
+}
+
+// merge the parts of u that are set into the url and values of the state
+func (s *encodeState) merge(u *url.URL) {
+	if u == nil {
+		return
+	}
+	if u.Scheme != "" {
+		s.u.Scheme = u.Scheme
+	}
+	if u.Opaque != "" {
+		s.u.Opaque = u.Opaque
+	}
+	if u.User != nil {
+		s.u.User = u.User
+	}
+	if u.Host != "" {
+		s.u.Host = u.Host
+	}
+	if u.Path != "" {
+		s.u.Path, s.u.RawPath = u.Path, u.RawPath
+	}
+	if u.Fragment != "" {
+		s.u.Fragment = u.Fragment
+	}
+	values, _ := parseQuery(u.RawQuery)
+	for k, v := range values {
+		s.values[k] = append(s.values[k], v...)
+	}
+}
+
+// uriMarshaler returns v as a Marshaler, methods with a pointer receiver
+// are found even if v is not addressable
+func uriMarshaler(v reflect.Value) (Marshaler, bool) {
+	if !reflect.PtrTo(v.Type()).Implements(marshalerType) {
+		return nil, false
+	}
+	if !v.CanAddr() {
+		p := reflect.New(v.Type())
+		p.Elem().Set(v)
+		v = p.Elem()
+	}
+	return v.Addr().Interface().(Marshaler), true
+}
 
+// format the url as a string using the escaping option of the Encoder
+func (e *Encoder) format(u *url.URL) (string, error) {
+	if e.opts.escape {
+		return u.String(), nil
+	}
+	return url.QueryUnescape(u.String())
 }
 
-func parseStruct(u *url.URL, uVal *url.Values, vStruct reflect.Value) {
-	for i := 0; i < vStruct.NumField(); i++ {
-		field := vStruct.Field(i)
-		if !field.CanInterface() {
-			continue // skip unexported variables
+// parseStruct adds the fields of vStruct to the url and values.
+// Fields that can not be marshaled are skipped and the first error is returned.
+func (e *Encoder) parseStruct(s *encodeState, vStruct reflect.Value) (err error) {
+	if m, ok := uriMarshaler(vStruct); ok {
+		u, err := m.MarshalURI()
+		if err != nil {
+			return &MarshalError{Type: vStruct.Type(), Err: err}
 		}
+		s.merge(u)
+		return nil
+	}
+	u, uVal := s.u, s.values
+	addErr := func(e error) {
+		if err == nil {
+			err = e
+		}
+	}
+	fields := e.fields(vStruct.Type())
+	for i := range fields {
+		f := &fields[i]
+		field := vStruct.Field(f.index)
 		// check for embedded struct and handle recursively
-		if field.Kind() == reflect.Struct {
-			ptr := reflect.New(field.Type())
-			if !implementsMarshaler(ptr) {
-				parseStruct(u, uVal, field)
+		if f.embedded {
+			if field.Kind() == reflect.Struct {
+				addErr(e.parseNested(s, field, f))
 				continue
-			}
-		} else if field.Kind() == reflect.Ptr && field.Elem().Kind() == reflect.Struct {
-			if !implementsMarshaler(field) {
-				parseStruct(u, uVal, field.Elem())
+			} else if !field.IsNil() {
+				addErr(e.parseNested(s, field.Elem(), f))
 				continue
 			}
 		}
-		var name string
-		structTag := vStruct.Type().Field(i).Tag
-		tag := parseURITag(structTag)
+		if f.structs {
+			addErr(e.parseStructs(s, field, f))
+			continue
+		}
+		name := e.opts.key(s.prefix, f.name)
+		structTag := f.sField.Tag
 
-		fs := GetFieldString(field, structTag)
+		def := f.def
+		fs, fErr := e.fieldString(field, structTag)
+		if fErr != nil {
+			// zero values without a default are never written
+			if !(def == "" && isZero(field)) {
+				addErr(&MarshalError{Field: f.sField.Name, Type: field.Type(), Err: fErr})
+			}
+			continue
+		}
 
-		switch tag {
+		if f.param != "" {
+			s.params[f.param] = fs
+			continue
+		}
+		if f.pos != nil {
+			values := []string{fs}
+			if f.pos.isRange && (field.Kind() == reflect.Slice || field.Kind() == reflect.Array) {
+				values = make([]string, field.Len())
+				for j := range values {
+					values[j], _ = e.fieldString(field.Index(j), structTag)
+				}
+			}
+			s.pos = append(s.pos, posValue{f: f, pos: f.pos, values: values})
+			continue
+		}
+
+		switch f.tag {
 		case scheme:
 			u.Scheme = fs
 			continue
 		case host:
 			u.Host = fs
 			continue
+		case hostname:
+			s.hostname = &fs
+			continue
+		case port:
+			if def == "" && isZero(field) {
+				continue // keep the port of the host
+			}
+			s.port = &fs
+			continue
 		case path:
 			u.Path = fs
 			continue
 		case fragment:
 			u.Fragment = fs
 			continue
+		case filename:
+			if fs == "" && def == "" {
+				continue // keep the filename of the path
+			}
+			p, _ := filepath.Split(u.Path)
+			u.Path = p + fs
+			continue
+		case dir:
+			if fs == "" && def == "" {
+				continue // keep the dir of the path
+			}
+			_, file := filepath.Split(u.Path)
+			if fs != "" && !strings.HasSuffix(fs, "/") {
+				fs += "/"
+			}
+			u.Path = fs + file
+			continue
 		case userinfo:
 			u.User = url.User(fs)
 			continue
@@ -135,12 +410,7 @@ func parseStruct(u *url.URL, uVal *url.Values, vStruct reflect.Value) {
 			continue
 		case "-": // skip disabled fields
 			continue
-		case "":
-			name = vStruct.Type().Field(i).Name
-		default:
-			name = tag
 		}
-		def := vStruct.Type().Field(i).Tag.Get(defaultTag)
 		// skip default fields
 		if def == "" && isZero(field) {
 			continue
@@ -148,75 +418,185 @@ func parseStruct(u *url.URL, uVal *url.Values, vStruct reflect.Value) {
 			continue
 		}
 
-		if field.Kind() == reflect.Slice {
-			for _, v := range strings.Split(fs, sliceDelim) {
+		isList := field.Kind() == reflect.Slice || field.Kind() == reflect.Array
+		switch {
+		case isList && e.opts.brackets:
+			for j := 0; j < field.Len(); j++ {
+				v, _ := e.fieldString(field.Index(j), structTag)
+				uVal.Add(name+"[]", v)
+			}
+		case field.Kind() == reflect.Map && e.opts.keyedMaps():
+			for _, kv := range e.mapEntries(field, structTag) {
+				uVal.Add(e.opts.key(name, kv[0]), kv[1])
+			}
+		case field.Kind() == reflect.Map && e.opts.mapStyle == MapRepeated:
+			for _, kv := range e.mapEntries(field, structTag) {
+				uVal.Add(name, kv[0]+e.opts.keySep+kv[1])
+			}
+		case isList && !e.opts.joinSlices:
+			for j := 0; j < field.Len(); j++ {
+				v, _ := e.fieldString(field.Index(j), structTag)
 				uVal.Add(name, v)
 			}
-		} else {
+		default:
 			uVal.Add(name, fs)
 		}
 	}
+	return err
+}
+
+// parseNested parses the struct of field f with its params prefixed
+// by the name of the field, see NestedPrefix
+func (e *Encoder) parseNested(s *encodeState, vStruct reflect.Value, f *fieldInfo) error {
+	if !e.opts.prefixNested() || f.sField.Anonymous {
+		return e.parseStruct(s, vStruct)
+	}
+	prefix := s.prefix
+	s.prefix = e.opts.key(s.prefix, f.name)
+	err := e.parseStruct(s, vStruct)
+	s.prefix = prefix
+	return err
+}
+
+// mapEntries returns the key/value pairs of a map as strings sorted by key
+func (e *Encoder) mapEntries(m reflect.Value, sTag reflect.StructTag) [][2]string {
+	entries := make([][2]string, 0, m.Len())
+	iter := m.MapRange()
+	for iter.Next() {
+		k, _ := e.fieldString(iter.Key(), sTag)
+		v, _ := e.fieldString(iter.Value(), sTag)
+		entries = append(entries, [2]string{k, v})
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i][0] < entries[j][0] })
+	return entries
+}
+
+// parseStructMap adds each value of a map of structs with keyed params, m.key.name=a.
+// nil values are skipped.
+func (e *Encoder) parseStructMap(s *encodeState, m reflect.Value, f *fieldInfo) (err error) {
+	prefix := s.prefix
+	iter := m.MapRange()
+	for iter.Next() {
+		elem := iter.Value()
+		if elem.Kind() == reflect.Ptr {
+			if elem.IsNil() {
+				continue
+			}
+			elem = elem.Elem()
+		}
+		k, kErr := e.fieldString(iter.Key(), f.sField.Tag)
+		if kErr != nil {
+			if err == nil {
+				err = &MarshalError{Field: f.sField.Name, Type: m.Type(), Err: kErr}
+			}
+			continue
+		}
+		s.prefix = e.opts.key(e.opts.key(prefix, f.name), k)
+		if pErr := e.parseStruct(s, elem); err == nil {
+			err = pErr
+		}
+	}
+	s.prefix = prefix
+	return err
+}
+
+// parseStructs adds each element of a slice of structs with indexed params, items[0].sku=a.
+// nil elements are skipped.
+func (e *Encoder) parseStructs(s *encodeState, list reflect.Value, f *fieldInfo) (err error) {
+	if list.Kind() == reflect.Map {
+		return e.parseStructMap(s, list, f)
+	}
+	prefix := s.prefix
+	for i := 0; i < list.Len(); i++ {
+		elem := list.Index(i)
+		if elem.Kind() == reflect.Ptr {
+			if elem.IsNil() {
+				continue
+			}
+			elem = elem.Elem()
+		}
+		s.prefix = e.opts.key(prefix, f.name) + "[" + strconv.Itoa(i) + "]"
+		if pErr := e.parseStruct(s, elem); err == nil {
+			err = pErr
+		}
+	}
+	s.prefix = prefix
+	return err
 }
 
 // GetFieldString returns a string representation of a Value
-// booleans become true/false
-// nil pointers return "nil"
-// slices combine elements with a comma. []int{1,2,3} -> "1,2,3"
-func GetFieldString(value reflect.Value, sTag reflect.StructTag) string {
+// using the delimiters of the Encoder. See GetFieldString
+func (e *Encoder) GetFieldString(value reflect.Value, sTag reflect.StructTag) string {
+	s, _ := e.fieldString(value, sTag)
+	return s
+}
 
+// fieldString is GetFieldString that reports values which could not be converted
+func (e *Encoder) fieldString(value reflect.Value, sTag reflect.StructTag) (string, error) {
 	format := sTag.Get("format")
 	if format != "" {
-		if value.Type() == reflect.TypeOf(time.Time{}) {
-			return value.Interface().(time.Time).Format(format)
-		}
-		if value.Type() == reflect.TypeOf(&time.Time{}) {
-			return value.Interface().(*time.Time).Format(format)
+		if value.Type() == timeType {
+			return value.Interface().(time.Time).Format(format), nil
 		}
 	}
 
 	if format == "rune" && value.Kind() == reflect.Int32 {
-		return string(value.Interface().(rune))
+		return string(value.Interface().(rune)), nil
 	}
 
 	switch value.Kind() {
 	case reflect.String:
-		return value.Interface().(string)
+		return value.Interface().(string), nil
 	case reflect.Bool:
 		if value.Interface().(bool) == true {
-			return "true"
+			return "true", nil
 		}
-		return "false"
+		return "false", nil
 	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
-		return fmt.Sprintf("%v", value.Interface())
+		return fmt.Sprintf("%v", value.Interface()), nil
 	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
-		return fmt.Sprintf("%v", value.Interface())
+		return fmt.Sprintf("%v", value.Interface()), nil
 	case reflect.Float32, reflect.Float64:
-		return fmt.Sprintf("%v", value.Interface())
+		return fmt.Sprintf("%v", value.Interface()), nil
 	case reflect.Ptr:
 		if value.IsNil() {
-			return "nil"
+			return "nil", nil
 		}
-		return GetFieldString(value.Elem(), sTag)
-	case reflect.Slice:
-		var s string
-		for i := 0; i < value.Len(); i++ {
-			s += GetFieldString(value.Index(i), sTag) + sliceDelim
+		return e.fieldString(value.Elem(), sTag)
+	case reflect.Slice, reflect.Array:
+		s := make([]string, value.Len())
+		for i := range s {
+			v, err := e.fieldString(value.Index(i), sTag)
+			if err != nil {
+				return "", err
+			}
+			s[i] = v
 		}
-		return strings.TrimRight(s, sliceDelim)
+		return strings.Join(s, e.opts.sliceDelim), nil
 	case reflect.Struct:
-		s, _ := tryMarshal(value)
-		return s
+		return tryMarshal(value)
 	case reflect.Map:
 		iter := value.MapRange()
 		s := make([]string, 0)
 		for iter.Next() {
-			k := GetFieldString(iter.Key(), sTag)
-			v := GetFieldString(iter.Value(), sTag)
-			s = append(s, k+":"+v)
+			k, err := e.fieldString(iter.Key(), sTag)
+			if err != nil {
+				return "", err
+			}
+			v, err := e.fieldString(iter.Value(), sTag)
+			if err != nil {
+				return "", err
+			}
+			s = append(s, k+e.opts.keySep+v)
 		}
 		sort.Sort(sort.StringSlice(s)) // sorted for consistency
-		return strings.Join(s, mapDelim)
+		return strings.Join(s, e.opts.mapDelim), nil
+	case reflect.Interface:
+		if value.IsNil() {
+			return "", nil
+		}
+		return e.fieldString(value.Elem(), sTag)
 	default:
-		return ""
+		return "", &UnsupportedTypeError{Type: value.Type()}
 	}
 }
@@ -1,7 +1,9 @@
 package uri
 
 import (
+	"errors"
 	"fmt"
+	"net/url"
 	"reflect"
 	"testing"
 	"time"
@@ -90,6 +92,17 @@ func TestMarshal(t *testing.T) {
 			},
 			Expected: "?Ints=1&Ints=2&Ints=3&strings=hello&strings=world",
 		},
+		"arrays": {
+			Input: struct {
+				Coords [2]float64 `uri:"coords"`
+				Zero   [2]int
+				Bytes  [4]byte `uri:"bytes"`
+			}{
+				Coords: [2]float64{1.5, -2.25},
+				Bytes:  [4]byte{127, 0, 0, 1},
+			},
+			Expected: "?bytes=127&bytes=0&bytes=0&bytes=1&coords=1.5&coords=-2.25",
+		},
 		"*struct with values": {
 			Input: &struct {
 				Int    int
@@ -199,6 +212,79 @@ func TestMarshal(t *testing.T) {
 			},
 			Expected: "http://localhost:8080/path/to/file.txt",
 		},
+		"dir and filename": {
+			Input: struct {
+				File string `uri:"filename"`
+				Dir  string `uri:"dir"`
+			}{File: "file.gz", Dir: "/2024/01/02"},
+			Expected: "/2024/01/02/file.gz",
+		},
+		"empty filename keeps path": {
+			Input: struct {
+				Path string `uri:"path"`
+				File string `uri:"filename"`
+			}{Path: "/a/b.txt"},
+			Expected: "/a/b.txt",
+		},
+		"empty dir keeps path": {
+			Input: struct {
+				Path string `uri:"path"`
+				Dir  string `uri:"dir"`
+			}{Path: "/a/b.txt"},
+			Expected: "/a/b.txt",
+		},
+		"positional path tags": {
+			Input: struct {
+				Scheme string   `uri:"scheme"`
+				Host   string   `uri:"host"`
+				Year   int      `uri:"path[0]"`
+				Rest   []string `uri:"path[1:]"`
+				File   string   `uri:"path[-1]"`
+			}{Scheme: "s3", Host: "bucket", Year: 2024, Rest: []string{"01", "02"}, File: "file.gz"},
+			Expected: "s3://bucket/2024/01/02/file.gz",
+		},
+		"hostname and port": {
+			Input: struct {
+				Port     int    `uri:"port"`
+				Scheme   string `uri:"scheme"`
+				Hostname string `uri:"hostname"`
+			}{Port: 8080, Scheme: "http", Hostname: "localhost"},
+			Expected: "http://localhost:8080",
+		},
+		"port overrides host": {
+			Input: struct {
+				Host string `uri:"host"`
+				Port uint16 `uri:"port"`
+			}{Host: "localhost:80", Port: 8080},
+			Expected: "//localhost:8080",
+		},
+		"zero port keeps host port": {
+			Input: struct {
+				Host string `uri:"host"`
+				Port int    `uri:"port"`
+			}{Host: "a.com:8080"},
+			Expected: "//a.com:8080",
+		},
+		"zero port": {
+			Input: struct {
+				Hostname string `uri:"hostname"`
+				Port     int    `uri:"port"`
+			}{Hostname: "localhost"},
+			Expected: "//localhost",
+		},
+		"ipv6": {
+			Input: struct {
+				Hostname string `uri:"hostname"`
+				Port     string `uri:"port"`
+			}{Hostname: "::1", Port: "8080"},
+			Expected: "//[::1]:8080",
+		},
+		"ipv6 zone": {
+			Input: struct {
+				Hostname string `uri:"hostname"`
+			}{Hostname: "fe80::1%en0"},
+			Expected: "//[fe80::1%en0]",
+		},
 		"origin": {
 			Input: struct {
 				Origin string `uri:"origin"`
@@ -349,3 +435,489 @@ func TestMarshal(t *testing.T) {
 	}
 	trial.New(fn, cases).SubTest(t)
 }
+
+func TestEncoder(t *testing.T) {
+	type data struct {
+		Ints   []int          `uri:"ints" qs:"i"`
+		Map    map[string]int `uri:"map" qs:"m"`
+		String string         `json:"value"`
+	}
+	fn := func(args ...interface{}) (interface{}, error) {
+		e := NewEncoder(args[1].([]Option)...)
+		return e.Marshal(args[0]), nil
+	}
+	v := data{Ints: []int{1, 2}, Map: map[string]int{"a": 1, "b": 2}, String: "a b"}
+	cases := trial.Cases{
+		"default options": {
+			Input:    trial.Args(v, []Option{}),
+			Expected: "?ints=1&ints=2&map=a%3A1%7Cb%3A2&value=a+b",
+		},
+		"join slices": {
+			Input:    trial.Args(v, []Option{JoinSlices(true), Escape(false)}),
+			Expected: "?ints=1,2&map=a:1|b:2&value=a b",
+		},
+		"slice delim": {
+			Input:    trial.Args(v, []Option{JoinSlices(true), SliceDelim(";"), Escape(false)}),
+			Expected: "?ints=1;2&map=a:1|b:2&value=a b",
+		},
+		"map delim and key sep": {
+			Input:    trial.Args(v, []Option{MapDelim(","), KeySep("="), Escape(false)}),
+			Expected: "?ints=1&ints=2&map=a=1,b=2&value=a b",
+		},
+		"tag name": {
+			Input:    trial.Args(v, []Option{TagName("qs"), Escape(false)}),
+			Expected: "?i=1&i=2&m=a:1|b:2&value=a b",
+		},
+		"without json tag": {
+			Input:    trial.Args(v, []Option{JSONTag(false), Escape(false)}),
+			Expected: "?String=a b&ints=1&ints=2&map=a:1|b:2",
+		},
+	}
+	trial.New(fn, cases).SubTest(t)
+}
+
+func TestEncoderRoundTrip(t *testing.T) {
+	type data struct {
+		Strings []string          `uri:"s"`
+		Map     map[string]string `uri:"m"`
+	}
+	opts := []Option{JoinSlices(true), SliceDelim(";"), MapDelim(","), KeySep("=")}
+	v := data{Strings: []string{"a,b", "c"}, Map: map[string]string{"k": "v:1", "x": "y|z"}}
+	s := NewEncoder(opts...).Marshal(v)
+
+	var result data
+	if err := NewDecoder(opts...).Unmarshal(s, &result); err != nil {
+		t.Fatal(err)
+	}
+	if eq, diff := trial.Equal(result, v); !eq {
+		t.Errorf("round trip %s: %s", s, diff)
+	}
+}
+
+func TestPathRoundTrip(t *testing.T) {
+	type key struct {
+		Path   string `uri:"path"`
+		Bucket string `uri:"path[0]"`
+		File   string `uri:"path[-1]"`
+	}
+	v := key{Path: "/a/b/c.gz", Bucket: "a", File: "c.gz"}
+	s := Marshal(v)
+	if s != "/a/b/c.gz" {
+		t.Errorf("expected /a/b/c.gz got %s", s)
+	}
+	var result key
+	if err := Unmarshal(s, &result); err != nil {
+		t.Fatal(err)
+	}
+	if eq, diff := trial.Equal(result, v); !eq {
+		t.Errorf("round trip %s: %s", s, diff)
+	}
+
+	// positional values replace the segments of the path
+	if s := Marshal(key{Path: "/a/b/c.gz", Bucket: "x"}); s != "/x/b/c.gz" {
+		t.Errorf("expected /x/b/c.gz got %s", s)
+	}
+
+	type positions struct {
+		Host  string   `uri:"host"`
+		Year  int      `uri:"path[0]"`
+		Month string   `uri:"path[1]"`
+		Dirs  []string `uri:"path[2:4]"`
+		Last  []string `uri:"path[-2:]"`
+	}
+	for _, v := range []positions{
+		{Host: "h", Year: 2024, Month: "01", Dirs: []string{"a", "b"}, Last: []string{"c", "d.gz"}},
+		{Year: 2024, Month: "01", Dirs: []string{"a", "b"}, Last: []string{"c", "d.gz"}},
+		{Host: "h", Year: 2024, Month: "", Dirs: []string{"a", "b"}, Last: []string{"c", "d.gz"}},
+	} {
+		s, err := MarshalE(v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var result positions
+		if err := Unmarshal(s, &result); err != nil {
+			t.Fatal(err)
+		}
+		if eq, diff := trial.Equal(result, v); !eq {
+			t.Errorf("round trip %s: %s", s, diff)
+		}
+	}
+}
+
+func BenchmarkMarshal(b *testing.B) {
+	v := benchStruct{
+		Scheme:   "https",
+		Host:     "localhost:8080",
+		Path:     "/path/to/file.txt",
+		Name:     "hello",
+		Limit:    20,
+		Ids:      []int{1, 2, 3},
+		Tags:     map[string]string{"a": "1", "b": "2"},
+		Start:    trial.TimeDay("2020-01-02"),
+		Dura:     time.Hour,
+		Embedded: Embedded{Int: 7, String: "world"},
+	}
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		Marshal(v)
+	}
+}
+
+type errMarshaler struct{}
+
+func (errMarshaler) MarshalText() ([]byte, error) {
+	return nil, errors.New("marshal failed")
+}
+
+// ptrText implements the text interfaces with a pointer receiver
+type ptrText struct {
+	s string
+}
+
+func (p *ptrText) MarshalText() ([]byte, error) {
+	return []byte(p.s), nil
+}
+
+func (p *ptrText) UnmarshalText(b []byte) error {
+	p.s = string(b)
+	return nil
+}
+
+func TestMarshalE(t *testing.T) {
+	fn := func(args ...interface{}) (interface{}, error) {
+		return MarshalE(args[0])
+	}
+	cases := trial.Cases{
+		"struct": {
+			Input: struct {
+				Int    int
+				String string
+			}{Int: 10, String: "hello world"},
+			Expected: "?Int=10&String=hello+world",
+		},
+		"nil *time.Time with format": {
+			Input: struct {
+				Time *time.Time `format:"2006-01-02"`
+				Int  int
+			}{Int: 1},
+			Expected: "?Int=1",
+		},
+		"ipv6 zone": {
+			Input: struct {
+				Hostname string `uri:"hostname"`
+				Port     int    `uri:"port"`
+			}{Hostname: "fe80::1%en0", Port: 8080},
+			Expected: "//[fe80::1%25en0]:8080",
+		},
+		"negative path range": {
+			Input: struct {
+				T []string `uri:"path[-2:]"`
+			}{T: []string{"a", "b"}},
+			Expected: "/a/b",
+		},
+		"too many segments for negative range": {
+			Input: struct {
+				T []string `uri:"path[-2:]"`
+			}{T: []string{"a", "b", "c"}},
+			ExpectedErr: errors.New("uri: can not marshal T ([]string): 3 segments do not fit in path[-2:]"),
+		},
+		"too many segments for range": {
+			Input: struct {
+				T []string `uri:"path[1:3]"`
+			}{T: []string{"a", "b", "c", "d"}},
+			ExpectedErr: errors.New("uri: can not marshal T ([]string): 4 segments do not fit in path[1:3]"),
+		},
+		"empty first segment": {
+			Input: struct {
+				Seg string `uri:"path[1]"`
+			}{Seg: "x"},
+			ExpectedErr: errors.New("uri: can not marshal Seg (string): path[1] leaves the first segment of the path empty"),
+		},
+		"non struct": {
+			Input:       10,
+			ExpectedErr: trial.ErrType(&InvalidMarshalError{}),
+		},
+		"nil": {
+			Input:       nil,
+			ExpectedErr: trial.ErrType(&InvalidMarshalError{}),
+		},
+		"MarshalText error": {
+			Input: struct {
+				Value []errMarshaler
+			}{Value: []errMarshaler{{}}},
+			ExpectedErr: errors.New("marshal failed"),
+		},
+		"unsupported type": {
+			Input: struct {
+				Chan chan int
+			}{Chan: make(chan int)},
+			ExpectedErr: trial.ErrType(&MarshalError{}),
+		},
+		"nil unsupported type": {
+			Input: struct {
+				Func func()
+				Int  int
+			}{Int: 1},
+			Expected: "?Int=1",
+		},
+		"pointer receiver MarshalText": {
+			Input: struct {
+				P     ptrText    `uri:"p"`
+				Ptrs  []*ptrText `uri:"ptrs"`
+				Array [1]ptrText `uri:"a"`
+			}{P: ptrText{s: "hi"}, Ptrs: []*ptrText{{s: "x"}}, Array: [1]ptrText{{s: "y"}}},
+			Expected: "?a=y&p=hi&ptrs=x",
+		},
+		"struct without MarshalText": {
+			Input: struct {
+				Structs [1]bStruct
+			}{Structs: [1]bStruct{{Name: "a"}}},
+			ExpectedErr: errors.New("unsupported type uri.bStruct"),
+		},
+		"interface": {
+			Input: struct {
+				Value interface{}
+				Nil   interface{}
+			}{Value: 12},
+			Expected: "?Value=12",
+		},
+	}
+	trial.New(fn, cases).SubTest(t)
+}
+
+func TestMarshalPanic(t *testing.T) {
+	defer func() {
+		if r := recover(); r == nil {
+			t.Error("expected Marshal to panic")
+		}
+	}()
+	Marshal("hello")
+}
+
+func TestMarshalURL(t *testing.T) {
+	v := struct {
+		Scheme string   `uri:"scheme"`
+		Host   string   `uri:"host"`
+		Path   string   `uri:"path"`
+		Name   string   `uri:"name"`
+		Ints   []int    `uri:"ints"`
+		Chan   chan int `uri:"chan"`
+	}{Scheme: "https", Host: "localhost", Path: "/a/b", Name: "a b", Ints: []int{1, 2}}
+	u, err := MarshalURL(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := &url.URL{Scheme: "https", Host: "localhost", Path: "/a/b", RawQuery: "ints=1&ints=2&name=a+b"}
+	if eq, diff := trial.Equal(u, expected); !eq {
+		t.Error(diff)
+	}
+
+	v.Chan = make(chan int)
+	if u, err := MarshalURL(v); err == nil || u != nil {
+		t.Errorf("expected error for chan got %v", u)
+	}
+}
+
+func TestMarshalValues(t *testing.T) {
+	fn := func(args ...interface{}) (interface{}, error) {
+		return MarshalValues(args[0])
+	}
+	cases := trial.Cases{
+		"values": {
+			Input: struct {
+				Host  string `uri:"host"`
+				Name  string `uri:"name"`
+				Ints  []int  `uri:"ints"`
+				Limit int    `uri:"limit" default:"10"`
+				Zero  string `uri:"zero"`
+			}{Host: "localhost", Name: "a;b", Ints: []int{1, 2}, Limit: 10},
+			Expected: url.Values{"name": {"a;b"}, "ints": {"1", "2"}},
+		},
+		"invalid": {
+			Input:     []int{},
+			ShouldErr: true,
+		},
+	}
+	trial.New(fn, cases).SubTest(t)
+}
+
+func TestMarshaler(t *testing.T) {
+	fn := func(args ...interface{}) (interface{}, error) {
+		return MarshalE(args[0])
+	}
+	cases := trial.Cases{
+		"top level": {
+			Input:    bucket{Name: "data", Prefix: "logs"},
+			Expected: "s3://data/logs",
+		},
+		"pointer": {
+			Input:    &bucket{Name: "data"},
+			Expected: "s3://data/",
+		},
+		"nested": {
+			Input:    bucketConfig{Bucket: bucket{Name: "data", Prefix: "logs"}, Region: "us-east-1"},
+			Expected: "s3://data/logs?region=us-east-1",
+		},
+		"error": {
+			Input:       bucketConfig{Region: "us-east-1"},
+			ExpectedErr: errors.New("bucket name is required"),
+		},
+	}
+	trial.New(fn, cases).SubTest(t)
+}
+
+func TestEncoderNestedPrefix(t *testing.T) {
+	fn := func(args ...interface{}) (interface{}, error) {
+		return NewEncoder(NestedPrefix(".")).MarshalE(args[0])
+	}
+	cases := trial.Cases{
+		"nested": {
+			Input: nestedQuery{
+				Name:     "a",
+				Filter:   nestedFilter{Name: "b", Age: 3},
+				Owner:    &nestedFilter{Name: "c"},
+				Embedded: Embedded{Int: 1},
+			},
+			Expected: "?Int=1&filter.age=3&filter.name=b&name=a&owner.name=c",
+		},
+		"nil pointer": {
+			Input:    nestedQuery{Filter: nestedFilter{Age: 3}},
+			Expected: "?filter.age=3",
+		},
+	}
+	trial.New(fn, cases).SubTest(t)
+
+	// round trip with the same options
+	v := nestedQuery{Filter: nestedFilter{Name: "b"}, Owner: &nestedFilter{Age: 2}}
+	s := NewEncoder(NestedPrefix("_")).Marshal(v)
+	got := nestedQuery{}
+	if err := NewDecoder(NestedPrefix("_")).Unmarshal(s, &got); err != nil {
+		t.Fatal(err)
+	}
+	if eq, diff := trial.Equal(got, v); !eq {
+		t.Error(diff)
+	}
+}
+
+func TestMarshalStructSlice(t *testing.T) {
+	fn := func(args ...interface{}) (interface{}, error) {
+		return NewEncoder(Escape(false)).MarshalE(args[0])
+	}
+	cases := trial.Cases{
+		"items": {
+			Input:    orderItems{Items: []bStruct{{Name: "a", Value: 2}, {Name: "b"}}},
+			Expected: "?items[0].Name=a&items[0].Value=2&items[1].Name=b",
+		},
+		"pointers": {
+			Input:    orderItems{Ptrs: []*bStruct{{Name: "a"}, nil, {Value: 3}}},
+			Expected: "?ptrs[0].Name=a&ptrs[2].Value=3",
+		},
+		"with params": {
+			Input:    orderItems{Items: []bStruct{{Name: "a"}}, Note: "x"},
+			Expected: "?items[0].Name=a&note=x",
+		},
+	}
+	trial.New(fn, cases).SubTest(t)
+
+	// round trip
+	v := orderItems{Items: []bStruct{{Name: "a", Value: 2}, {Name: "b", Value: 10}}, Ptrs: []*bStruct{{Value: 1}}}
+	got := orderItems{}
+	if err := Unmarshal(Marshal(v), &got); err != nil {
+		t.Fatal(err)
+	}
+	if eq, diff := trial.Equal(got, v); !eq {
+		t.Error(diff)
+	}
+}
+
+func TestEncoderBrackets(t *testing.T) {
+	fn := func(args ...interface{}) (interface{}, error) {
+		return NewEncoder(Brackets(true), Escape(false)).MarshalE(args[0])
+	}
+	cases := trial.Cases{
+		"slices": {
+			Input:    qsQuery{IDs: []int{1, 2}, Tags: []string{"a,b"}},
+			Expected: "?ids[]=1&ids[]=2&tag[]=a,b",
+		},
+		"maps": {
+			Input:    qsQuery{Labels: map[string]int{"a": 1, "b": 2}},
+			Expected: "?labels[a]=1&labels[b]=2",
+		},
+		"nested structs": {
+			Input:    qsQuery{Filter: qsFilter{Status: "open", Owner: nestedFilter{Name: "me"}}, Page: 2},
+			Expected: "?filter[owner][name]=me&filter[status]=open&page=2",
+		},
+		"slice of structs": {
+			Input:    qsQuery{Items: []bStruct{{Name: "a"}, {Value: 2}}},
+			Expected: "?items[0][Name]=a&items[1][Value]=2",
+		},
+	}
+	trial.New(fn, cases).SubTest(t)
+
+	// round trip
+	v := qsQuery{
+		IDs:    []int{3, 1},
+		Tags:   []string{"a,b", "c"},
+		Labels: map[string]int{"x|y": 1},
+		Filter: qsFilter{Owner: nestedFilter{Age: 4}},
+		Items:  []bStruct{{Name: "a"}},
+	}
+	got := qsQuery{}
+	if err := NewDecoder(Brackets(true)).Unmarshal(NewEncoder(Brackets(true)).Marshal(v), &got); err != nil {
+		t.Fatal(err)
+	}
+	if eq, diff := trial.Equal(got, v); !eq {
+		t.Error(diff)
+	}
+}
+
+func TestEncoderMapFormat(t *testing.T) {
+	fn := func(args ...interface{}) (interface{}, error) {
+		opts := []Option{Escape(false)}
+		for _, o := range args[1:] {
+			opts = append(opts, o.(Option))
+		}
+		return NewEncoder(opts...).MarshalE(args[0])
+	}
+	v := mapQuery{
+		Labels: map[string]int{"b": 2, "a": 1},
+		Groups: map[string][]string{"x": {"1", "2"}},
+	}
+	cases := trial.Cases{
+		"joined": {
+			Input:    trial.Args(v),
+			Expected: "?groups=x:1,2&labels=a:1|b:2",
+		},
+		"repeated": {
+			Input:    trial.Args(v, MapFormat(MapRepeated)),
+			Expected: "?groups=x:1,2&labels=a:1&labels=b:2",
+		},
+		"keys": {
+			Input:    trial.Args(v, MapFormat(MapKeys)),
+			Expected: "?groups.x=1,2&labels.a=1&labels.b=2",
+		},
+		"keys with brackets": {
+			Input:    trial.Args(v, MapFormat(MapKeys), Brackets(true)),
+			Expected: "?groups[x]=1,2&labels[a]=1&labels[b]=2",
+		},
+		"map of structs": {
+			Input:    trial.Args(mapQuery{Users: map[string]bStruct{"bob": {Name: "Bob"}}, Ptrs: map[int]*bStruct{3: {Value: 1}, 4: nil}}),
+			Expected: "?ptrs.3.Value=1&users.bob.Name=Bob",
+		},
+	}
+	trial.New(fn, cases).SubTest(t)
+
+	// round trip each style
+	v.Users = map[string]bStruct{"bob": {Name: "Bob", Value: 2}}
+	v.Ptrs = map[int]*bStruct{3: {Name: "c"}}
+	for _, style := range []MapStyle{MapJoined, MapRepeated, MapKeys} {
+		got := mapQuery{}
+		s := NewEncoder(MapFormat(style)).Marshal(v)
+		if err := NewDecoder(MapFormat(style)).Unmarshal(s, &got); err != nil {
+			t.Fatal(err)
+		}
+		if eq, diff := trial.Equal(got, v); !eq {
+			t.Errorf("style %d: %s", style, diff)
+		}
+	}
+}
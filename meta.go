@@ -0,0 +1,77 @@
+package uri
+
+import (
+	"net/url"
+	"sort"
+)
+
+// Source describes where the value of a struct field came from
+type Source int
+
+const (
+	Untouched   Source = iota // the field was not changed
+	FromDefault               // set by its default tag
+	FromURI                   // set by the uri
+)
+
+func (s Source) String() string {
+	switch s {
+	case FromDefault:
+		return "default"
+	case FromURI:
+		return "uri"
+	}
+	return "untouched"
+}
+
+// Meta lists the source of each struct field that was populated by UnmarshalWithMeta.
+// Fields are keyed by their struct field name, fields of named nested structs
+// are joined with a dot, Range.Start. Untouched fields are not in the map.
+type Meta map[string]Source
+
+// IsSet reports if the field was set by the uri
+func (m Meta) IsSet(field string) bool {
+	return m[field] == FromURI
+}
+
+// Fields returns the sorted names of the fields that were set by the uri
+func (m Meta) Fields() []string {
+	fields := make([]string, 0, len(m))
+	for k, src := range m {
+		if src == FromURI {
+			fields = append(fields, k)
+		}
+	}
+	sort.Strings(fields)
+	return fields
+}
+
+// UnmarshalWithMeta is the same as Unmarshal and also returns the source of each field.
+// It tells a param that was given as its zero value, ?limit=0,
+// apart from one that was missing, which is needed for partial updates.
+func UnmarshalWithMeta(uri string, v interface{}) (Meta, error) {
+	return defaultDecoder.UnmarshalWithMeta(uri, v)
+}
+
+// UnmarshalWithMeta is the same as Unmarshal and also returns the source of each field
+// using the options of the Decoder. See UnmarshalWithMeta
+func (d *Decoder) UnmarshalWithMeta(uri string, v interface{}) (Meta, error) {
+	u, err := url.Parse(uri)
+	if err != nil {
+		return nil, err
+	}
+	values, err := parseQuery(u.RawQuery)
+	if err != nil {
+		return nil, err
+	}
+	meta := make(Meta)
+	err = d.decode(&decodeState{u: u, values: values, meta: meta}, v)
+	return meta, err
+}
+
+// mark the source of field f when meta is tracked
+func (s *decodeState) mark(f *fieldInfo, src Source) {
+	if s.meta != nil {
+		s.meta[s.fieldPath+f.sField.Name] = src
+	}
+}
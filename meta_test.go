@@ -0,0 +1,52 @@
+package uri
+
+import (
+	"testing"
+
+	"github.com/jbsmith7741/trial"
+)
+
+type patchRequest struct {
+	Name   string `uri:"name"`
+	Limit  int    `uri:"limit" default:"10"`
+	Active bool   `uri:"active"`
+	Path   string `uri:"path"`
+	Embedded
+	Range *struct {
+		Start int `uri:"start"`
+		End   int `uri:"end" default:"100"`
+	}
+}
+
+func TestUnmarshalWithMeta(t *testing.T) {
+	fn := func(args ...interface{}) (interface{}, error) {
+		return UnmarshalWithMeta(args[0].(string), &patchRequest{})
+	}
+	cases := trial.Cases{
+		"zero values": {
+			Input:    "?limit=0&active=false",
+			Expected: Meta{"Limit": FromURI, "Active": FromURI, "Range.End": FromDefault},
+		},
+		"defaults": {
+			Input:    "?name=a",
+			Expected: Meta{"Name": FromURI, "Limit": FromDefault, "Range.End": FromDefault},
+		},
+		"keywords and nested": {
+			Input:    "/a/b?Int=1&start=0&end=5",
+			Expected: Meta{"Path": FromURI, "Limit": FromDefault, "Int": FromURI, "Range.Start": FromURI, "Range.End": FromURI},
+		},
+		"invalid values are not set": {
+			Input:     "?limit=x&name=a",
+			ShouldErr: true,
+		},
+	}
+	trial.New(fn, cases).SubTest(t)
+
+	meta, _ := UnmarshalWithMeta("?limit=x&name=a", &patchRequest{})
+	if eq, diff := trial.Equal(meta.Fields(), []string{"Name"}); !eq {
+		t.Error(diff)
+	}
+	if meta.IsSet("Limit") || meta["Limit"] != FromDefault || meta["Active"] != Untouched {
+		t.Errorf("unexpected sources %v", meta)
+	}
+}
@@ -0,0 +1,178 @@
+package uri
+
+import (
+	"reflect"
+	"strings"
+)
+
+// Option configures a Decoder or Encoder.
+// The same options can be given to both so that an Encoder and Decoder agree on a uri dialect.
+type Option func(*options)
+
+type options struct {
+	sliceDelim string
+	mapDelim   string
+	keySep     string
+	tagName    string
+	jsonTag    bool
+	nestedSep  string
+	brackets   bool
+	mapStyle   MapStyle
+
+	// decoding only
+	disallowUnknown bool
+	skipInvalid     bool
+	maxIndex        int
+
+	// encoding only
+	joinSlices bool
+	escape     bool
+}
+
+func newOptions(opts []Option) options {
+	o := options{
+		sliceDelim: sliceDelim,
+		mapDelim:   mapDelim,
+		keySep:     keySep,
+		tagName:    uriTag,
+		jsonTag:    true,
+		escape:     true,
+		maxIndex:   100,
+	}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
+
+// SliceDelim sets the separator between slice elements (default ",")
+func SliceDelim(s string) Option {
+	return func(o *options) { o.sliceDelim = s }
+}
+
+// MapDelim sets the separator between map key/value pairs (default "|")
+func MapDelim(s string) Option {
+	return func(o *options) { o.mapDelim = s }
+}
+
+// KeySep sets the separator between a map key and its value (default ":")
+func KeySep(s string) Option {
+	return func(o *options) { o.keySep = s }
+}
+
+// TagName sets the struct tag used for param names and special keywords (default "uri")
+func TagName(s string) Option {
+	return func(o *options) { o.tagName = s }
+}
+
+// JSONTag enables or disables the fallback to the json struct tag
+// when the uri tag is missing (default true)
+func JSONTag(enabled bool) Option {
+	return func(o *options) { o.jsonTag = enabled }
+}
+
+// NestedPrefix prefixes the params of named nested struct fields with the
+// name of the field and sep, ?filter.name=x for the Name field of Filter.
+// Anonymous embedded structs are not prefixed.
+// The default "" keeps the params of all nested structs flat.
+func NestedPrefix(sep string) Option {
+	return func(o *options) { o.nestedSep = sep }
+}
+
+// Brackets uses the syntax of the qs, Rails and PHP libraries for slices ?a[]=1&a[]=2,
+// maps ?m[key]=v and named nested structs ?filter[status]=open.
+// Values are not split by the SliceDelim and JoinSlices is ignored.
+// The Decoder also accepts indexed slices ?a[0]=1&a[1]=2 and repeated params ?a=1&a=2.
+func Brackets(enabled bool) Option {
+	return func(o *options) { o.brackets = enabled }
+}
+
+// MapStyle is the format of the params of a map
+type MapStyle int
+
+const (
+	MapJoined   MapStyle = iota // ?map=a:1|b:2
+	MapRepeated                 // ?map=a:1&map=b:2
+	MapKeys                     // ?map.a=1&map.b=2
+)
+
+// MapFormat sets how the Encoder writes maps (default MapJoined).
+// The Decoder accepts joined and repeated params for all styles,
+// keyed params are only accepted with MapKeys. The separator of MapKeys is
+// the one set by NestedPrefix or a dot. Maps of structs always use keyed params.
+func MapFormat(style MapStyle) Option {
+	return func(o *options) { o.mapStyle = style }
+}
+
+// DisallowUnknownParams causes the Decoder to return an UnknownParamError
+// for each query param that does not map to a struct field.
+func DisallowUnknownParams() Option {
+	return func(o *options) { o.disallowUnknown = true }
+}
+
+// SkipInvalidElements leaves slice elements and pointers that can not be
+// converted as zero values instead of returning an error. ?ints=1,x,3 becomes [1,0,3]
+func SkipInvalidElements() Option {
+	return func(o *options) { o.skipInvalid = true }
+}
+
+// MaxIndex sets the largest index accepted for a slice of structs,
+// ?items[0].sku=a (default 100). Larger indices return an error instead of
+// allocating a slice of that size. Only used by the Decoder.
+func MaxIndex(n int) Option {
+	return func(o *options) { o.maxIndex = n }
+}
+
+// JoinSlices marshals slices as a single delimited param (?a=1,2,3)
+// instead of repeating the param for each element (?a=1&a=2&a=3).
+// Only used by the Encoder, the Decoder accepts both formats.
+func JoinSlices(enabled bool) Option {
+	return func(o *options) { o.joinSlices = enabled }
+}
+
+// Escape enables or disables url encoding of the marshaled uri (default true).
+// Only used by the Encoder. See MarshalUnescaped
+func Escape(enabled bool) Option {
+	return func(o *options) { o.escape = enabled }
+}
+
+// elemSep is the separator after the index of a slice of structs or the key of a map
+func (o options) elemSep() string {
+	if o.nestedSep != "" {
+		return o.nestedSep
+	}
+	return "."
+}
+
+// key joins a param name to the key of its parent struct, filter.name or filter[name]
+func (o options) key(parent, name string) string {
+	switch {
+	case parent == "":
+		return name
+	case o.brackets:
+		return parent + "[" + name + "]"
+	}
+	return parent + o.elemSep() + name
+}
+
+// keyedMaps reports if map entries are params of their own, m.key or m[key]
+func (o options) keyedMaps() bool {
+	return o.mapStyle == MapKeys || o.brackets
+}
+
+// prefixNested reports if the params of named nested structs are prefixed
+func (o options) prefixNested() bool {
+	return o.nestedSep != "" || o.brackets
+}
+
+// fieldTag gets the structTag field from the configured tag or the jsonTag.
+// If the jsonTag value is found the only the value before the comma is returned.
+func (o options) fieldTag(v reflect.StructTag) string {
+	if tag := v.Get(o.tagName); tag != "" {
+		return tag
+	}
+	if !o.jsonTag {
+		return ""
+	}
+	return strings.Split(v.Get(jsonTag), ",")[0]
+}
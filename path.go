@@ -0,0 +1,165 @@
+package uri
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var regPathPos = regexp.MustCompile(`^path\[(-?\d+)(:(-?\d+)?)?\]$`)
+
+// pathPos is the position of the path segments from a path[i] or path[i:j] tag.
+// Negative positions count back from the last segment.
+type pathPos struct {
+	start   int
+	end     int
+	isRange bool
+	hasEnd  bool
+}
+
+// parsePathPos returns the position of a path[i], path[i:] or path[i:j] tag
+// or nil if the tag is not positional
+func parsePathPos(tag string) *pathPos {
+	m := regPathPos.FindStringSubmatch(tag)
+	if m == nil {
+		return nil
+	}
+	p := &pathPos{isRange: m[2] != "", hasEnd: m[3] != ""}
+	p.start, _ = strconv.Atoi(m[1])
+	if p.hasEnd {
+		p.end, _ = strconv.Atoi(m[3])
+	}
+	return p
+}
+
+// pathSegments of a path without the leading and trailing slash
+func pathSegments(path string) []string {
+	path = strings.Trim(path, "/")
+	if path == "" {
+		return nil
+	}
+	return strings.Split(path, "/")
+}
+
+// get the segments of the path at the position, false is returned
+// if the position is outside of the path
+func (p *pathPos) get(path string) ([]string, bool) {
+	segments := pathSegments(path)
+	start := p.start
+	if start < 0 {
+		start += len(segments)
+	}
+	if !p.isRange {
+		if start < 0 || start >= len(segments) {
+			return nil, false
+		}
+		return segments[start : start+1], true
+	}
+	end := len(segments)
+	if p.hasEnd {
+		end = p.end
+		if end < 0 {
+			end += len(segments)
+		}
+	}
+	if start < 0 {
+		start = 0
+	}
+	if end > len(segments) {
+		end = len(segments)
+	}
+	if start >= end {
+		return nil, false
+	}
+	return segments[start:end], true
+}
+
+// posValue is a marshaled value for a positional path tag
+type posValue struct {
+	f      *fieldInfo
+	pos    *pathPos
+	values []string
+}
+
+// size returns the segments needed before and after the values,
+// an error is returned if the values do not fit in the range of the position.
+func (v posValue) size() (head, tail int, err error) {
+	p, n := v.pos, len(v.values)
+	limit := -1
+	switch {
+	case p.start >= 0 && p.hasEnd && p.end >= 0:
+		limit = p.end - p.start
+		head = p.start + n
+	case p.start >= 0 && p.hasEnd:
+		head, tail = p.start+n, -p.end
+	case p.start >= 0:
+		head = p.start + n
+	case p.isRange && p.hasEnd && p.end < 0:
+		limit = p.end - p.start
+		tail = n - p.end
+	default:
+		limit = -p.start
+		tail = -p.start
+		if p.isRange {
+			tail = n
+		}
+	}
+	if limit >= 0 && n > limit {
+		return 0, 0, fmt.Errorf("%d segments do not fit in %s", n, v.f.tag)
+	}
+	return head, tail, nil
+}
+
+// buildPath merges the positional values into the segments of path. The path is extended
+// when it is too short and negative positions are placed after the last positive position.
+// Empty values do not replace existing segments. An error is returned if the values do not
+// fit in their range or the path would start with an empty segment, which can not be parsed back.
+func buildPath(path string, values []posValue) (string, error) {
+	segments := pathSegments(path)
+	head, tail := 0, 0
+	for _, v := range values {
+		h, t, err := v.size()
+		if err != nil {
+			return path, &MarshalError{Field: v.f.sField.Name, Type: v.f.sField.Type, Err: err}
+		}
+		if h > head {
+			head = h
+		}
+		if t > tail {
+			tail = t
+		}
+	}
+	n := head + tail
+	if len(segments) > n {
+		n = len(segments)
+	}
+	parts := make([]string, n)
+	copy(parts, segments)
+	first := -1 // value placed closest to the start of the path
+	firstAt := n
+	for k, v := range values {
+		i := v.pos.start
+		if i < 0 {
+			_, t, _ := v.size()
+			i = n - t
+		}
+		if i < firstAt {
+			first, firstAt = k, i
+		}
+		for j, s := range v.values {
+			if s != "" || parts[i+j] == "" {
+				parts[i+j] = s
+			}
+		}
+	}
+	// trailing empty segments are dropped when the path is parsed
+	for len(parts) > 0 && parts[len(parts)-1] == "" {
+		parts = parts[:len(parts)-1]
+	}
+	if len(parts) > 0 && parts[0] == "" && first >= 0 {
+		f := values[first].f
+		return path, &MarshalError{Field: f.sField.Name, Type: f.sField.Type, Err: fmt.Errorf("%s leaves the first segment of the path empty", f.tag)}
+	}
+	return "/" + strings.Join(parts, "/"), nil
+}
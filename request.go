@@ -0,0 +1,52 @@
+package uri
+
+import (
+	"mime"
+	"net/http"
+)
+
+// UnmarshalRequest copies the values of an http request to a predefined struct.
+// The url of the request is used directly without converting it back to a string,
+// the host keyword falls back to r.Host for server requests.
+//
+// Values are bound in the following order of precedence:
+//   - header - fields with a header tag `header:"X-Request-Id"` use the header when it is present
+//   - form body - params of an application/x-www-form-urlencoded POST, PUT or PATCH body (r.PostForm)
+//   - query - params from the url of the request
+func UnmarshalRequest(r *http.Request, v interface{}) error {
+	return defaultDecoder.UnmarshalRequest(r, v)
+}
+
+// UnmarshalRequest copies the values of an http request to a predefined struct
+// using the options of the Decoder. See UnmarshalRequest
+func (d *Decoder) UnmarshalRequest(r *http.Request, v interface{}) error {
+	values, err := parseQuery(r.URL.RawQuery)
+	if err != nil {
+		return err
+	}
+	if isForm(r) {
+		if err := r.ParseForm(); err != nil {
+			return err
+		}
+		// body params take precedence over the query params
+		for k, v := range r.PostForm {
+			values[k] = v
+		}
+	}
+	// server requests only have the path and query in the url
+	u := *r.URL
+	if u.Host == "" {
+		u.Host = r.Host
+	}
+	return d.decode(&decodeState{u: &u, values: values, header: r.Header}, v)
+}
+
+func isForm(r *http.Request) bool {
+	switch r.Method {
+	case http.MethodPost, http.MethodPut, http.MethodPatch:
+	default:
+		return false
+	}
+	ct, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	return ct == "application/x-www-form-urlencoded"
+}
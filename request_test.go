@@ -0,0 +1,77 @@
+package uri
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/jbsmith7741/trial"
+)
+
+func TestUnmarshalRequest(t *testing.T) {
+	type data struct {
+		Host      string   `uri:"host"`
+		Path      string   `uri:"path"`
+		Name      string   `uri:"name"`
+		Limit     int      `uri:"limit" default:"10"`
+		RequestID string   `uri:"request_id" header:"x-request-id"`
+		Langs     []string `header:"Accept-Language"`
+		Token     string   `uri:"token" required:"true"`
+	}
+	fn := func(args ...interface{}) (interface{}, error) {
+		v := &data{}
+		err := UnmarshalRequest(args[0].(*http.Request), v)
+		return v, err
+	}
+	get := func(target string, headers ...string) *http.Request {
+		r := httptest.NewRequest(http.MethodGet, target, nil)
+		for i := 0; i+1 < len(headers); i += 2 {
+			r.Header.Add(headers[i], headers[i+1])
+		}
+		return r
+	}
+	form := func(target, body string) *http.Request {
+		r := httptest.NewRequest(http.MethodPost, target, strings.NewReader(body))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded; charset=utf-8")
+		return r
+	}
+	cases := trial.Cases{
+		"query": {
+			Input:    get("/api/users?name=hello;world&token=abc"),
+			Expected: &data{Host: "example.com", Path: "/api/users", Name: "hello;world", Limit: 10, Token: "abc"},
+		},
+		"header": {
+			Input:    get("/?token=abc&request_id=query", "X-Request-ID", "header", "Accept-Language", "en", "Accept-Language", "fr"),
+			Expected: &data{Host: "example.com", Path: "/", Limit: 10, Token: "abc", RequestID: "header", Langs: []string{"en", "fr"}},
+		},
+		"header missing uses query": {
+			Input:    get("/?token=abc&request_id=query"),
+			Expected: &data{Host: "example.com", Path: "/", Limit: 10, Token: "abc", RequestID: "query"},
+		},
+		"form body": {
+			Input:    form("/?name=query&limit=5", "name=body&token=abc"),
+			Expected: &data{Host: "example.com", Path: "/", Name: "body", Limit: 5, Token: "abc"},
+		},
+		"form body on GET is ignored": {
+			Input:       get("/?name=query"),
+			ExpectedErr: errors.New("token is required"),
+		},
+		"invalid value": {
+			Input:       get("/?token=abc&limit=ten"),
+			ExpectedErr: &FieldError{Param: "limit", Value: "ten", Reason: "is not an int"},
+		},
+	}
+	trial.New(fn, cases).SubTest(t)
+
+	// the query param of a field is known when the header is used
+	v := &data{}
+	r := get("/?token=abc&request_id=query", "X-Request-ID", "header")
+	if err := NewDecoder(DisallowUnknownParams()).UnmarshalRequest(r, v); err != nil {
+		t.Fatal(err)
+	}
+	if v.RequestID != "header" {
+		t.Errorf("expected header request id got %q", v.RequestID)
+	}
+}
@@ -0,0 +1,154 @@
+package uri
+
+import (
+	"fmt"
+	"net/url"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// templates registered for struct types
+var templates sync.Map // map[reflect.Type]*pathTemplate
+
+// pathTemplate is a parsed path template /users/{id:int}/orders/{orderID}
+type pathTemplate struct {
+	raw      string
+	segments []segment
+}
+
+// segment of a path template, either a literal value or a named param
+type segment struct {
+	literal string
+	param   string
+	kind    string // int, uint, float or string
+}
+
+// RegisterTemplate sets the path template for the struct type of v.
+// Named segments of the template are bound to fields with a matching
+// uri tag in braces. The segment type is optional and is one of int, uint, float or string.
+//
+//	type Order struct {
+//	    User  int    `uri:"{id}"`
+//	    Order string `uri:"{orderID}"`
+//	}
+//	uri.RegisterTemplate(Order{}, "/users/{id:int}/orders/{orderID}")
+//
+// Unmarshal returns a TemplateError if the path does not match the template
+// and Marshal fills in the template to create the path.
+func RegisterTemplate(v interface{}, template string) error {
+	t := reflect.TypeOf(v)
+	if t != nil && t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t == nil || t.Kind() != reflect.Struct {
+		return fmt.Errorf("template %s: %v is not a struct", template, reflect.TypeOf(v))
+	}
+	tmpl, err := parseTemplate(template)
+	if err != nil {
+		return err
+	}
+	templates.Store(t, tmpl)
+	return nil
+}
+
+func lookupTemplate(t reflect.Type) *pathTemplate {
+	if tmpl, ok := templates.Load(t); ok {
+		return tmpl.(*pathTemplate)
+	}
+	return nil
+}
+
+func parseTemplate(s string) (*pathTemplate, error) {
+	t := &pathTemplate{raw: s}
+	for _, seg := range strings.Split(s, "/") {
+		if !strings.HasPrefix(seg, "{") || !strings.HasSuffix(seg, "}") {
+			if strings.ContainsAny(seg, "{}") {
+				return nil, fmt.Errorf("template %s: invalid segment %q", s, seg)
+			}
+			t.segments = append(t.segments, segment{literal: seg})
+			continue
+		}
+		param := strings.TrimSuffix(strings.TrimPrefix(seg, "{"), "}")
+		kind := "string"
+		if i := strings.Index(param, ":"); i != -1 {
+			param, kind = param[:i], param[i+1:]
+		}
+		switch kind {
+		case "int", "uint", "float", "string":
+		default:
+			return nil, fmt.Errorf("template %s: unknown type %q for %s", s, kind, param)
+		}
+		if param == "" {
+			return nil, fmt.Errorf("template %s: empty param name", s)
+		}
+		t.segments = append(t.segments, segment{param: param, kind: kind})
+	}
+	return t, nil
+}
+
+// match the path of the url against the template and return the values of the named segments.
+// The escaped path is split so an encoded slash (%2F) stays part of its segment.
+func (t *pathTemplate) match(u *url.URL) (map[string]string, error) {
+	path := u.Path
+	parts := strings.Split(u.EscapedPath(), "/")
+	if len(parts) != len(t.segments) {
+		return nil, &TemplateError{Template: t.raw, Path: path}
+	}
+	for i, p := range parts {
+		if s, err := url.PathUnescape(p); err == nil {
+			parts[i] = s
+		}
+	}
+	params := make(map[string]string)
+	for i, seg := range t.segments {
+		if seg.param == "" {
+			if parts[i] != seg.literal {
+				return nil, &TemplateError{Template: t.raw, Path: path}
+			}
+			continue
+		}
+		if !seg.matches(parts[i]) {
+			return nil, &TemplateError{Template: t.raw, Path: path, Param: seg.param}
+		}
+		params[seg.param] = parts[i]
+	}
+	return params, nil
+}
+
+func (seg segment) matches(s string) bool {
+	var err error
+	switch seg.kind {
+	case "int":
+		_, err = strconv.ParseInt(s, 10, 64)
+	case "uint":
+		_, err = strconv.ParseUint(s, 10, 64)
+	case "float":
+		_, err = strconv.ParseFloat(s, 64)
+	}
+	return err == nil && s != ""
+}
+
+// fill in the template with the params and set the path of the url
+func (t *pathTemplate) fill(u *url.URL, params map[string]string) {
+	path := make([]string, len(t.segments))
+	raw := make([]string, len(t.segments))
+	for i, seg := range t.segments {
+		if seg.param == "" {
+			path[i], raw[i] = seg.literal, seg.literal
+			continue
+		}
+		path[i], raw[i] = params[seg.param], url.PathEscape(params[seg.param])
+	}
+	u.Path = strings.Join(path, "/")
+	u.RawPath = strings.Join(raw, "/")
+}
+
+// templateParam returns the name of a path template param from a uri tag {name}
+func templateParam(tag string) string {
+	if len(tag) > 2 && strings.HasPrefix(tag, "{") && strings.HasSuffix(tag, "}") {
+		return tag[1 : len(tag)-1]
+	}
+	return ""
+}
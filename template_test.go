@@ -0,0 +1,112 @@
+package uri
+
+import (
+	"errors"
+	"net/url"
+	"testing"
+
+	"github.com/jbsmith7741/trial"
+)
+
+type orderRequest struct {
+	User   int    `uri:"{id}"`
+	Order  string `uri:"{orderID}"`
+	Expand bool   `uri:"expand"`
+}
+
+func init() {
+	if err := RegisterTemplate(orderRequest{}, "/users/{id:int}/orders/{orderID}"); err != nil {
+		panic(err)
+	}
+}
+
+func TestRegisterTemplate(t *testing.T) {
+	fn := func(args ...interface{}) (interface{}, error) {
+		return nil, RegisterTemplate(args[0], args[1].(string))
+	}
+	cases := trial.Cases{
+		"valid": {
+			Input: trial.Args(&struct{}{}, "/a/{b}/{c:float}"),
+		},
+		"not a struct": {
+			Input:     trial.Args("", "/a/{b}"),
+			ShouldErr: true,
+		},
+		"unknown type": {
+			Input:       trial.Args(struct{}{}, "/a/{b:date}"),
+			ExpectedErr: errors.New(`unknown type "date" for b`),
+		},
+		"invalid segment": {
+			Input:     trial.Args(struct{}{}, "/a/b{c}"),
+			ShouldErr: true,
+		},
+		"empty name": {
+			Input:     trial.Args(struct{}{}, "/a/{:int}"),
+			ShouldErr: true,
+		},
+	}
+	trial.New(fn, cases).SubTest(t)
+}
+
+func TestUnmarshalTemplate(t *testing.T) {
+	fn := func(args ...interface{}) (interface{}, error) {
+		v := &orderRequest{}
+		err := Unmarshal(args[0].(string), v)
+		return v, err
+	}
+	cases := trial.Cases{
+		"match": {
+			Input:    "https://example.com/users/12/orders/a%2Fb?expand",
+			Expected: &orderRequest{User: 12, Order: "a/b", Expand: true},
+		},
+		"literal mismatch": {
+			Input:       "/users/12/invoices/abc",
+			ExpectedErr: errors.New("path /users/12/invoices/abc does not match template /users/{id:int}/orders/{orderID}"),
+		},
+		"segment count": {
+			Input:     "/users/12/orders",
+			ShouldErr: true,
+		},
+		"invalid int": {
+			Input:       "/users/bob/orders/abc",
+			ExpectedErr: errors.New("invalid id"),
+		},
+	}
+	trial.New(fn, cases).SubTest(t)
+}
+
+func TestMarshalTemplate(t *testing.T) {
+	fn := func(args ...interface{}) (interface{}, error) {
+		return MarshalE(args[0])
+	}
+	cases := trial.Cases{
+		"fill": {
+			Input:    orderRequest{User: 12, Order: "abc", Expand: true},
+			Expected: "/users/12/orders/abc?expand=true",
+		},
+		"escaped": {
+			Input:    &orderRequest{User: 1, Order: "a/b c"},
+			Expected: "/users/1/orders/a%2Fb%20c",
+		},
+	}
+	trial.New(fn, cases).SubTest(t)
+}
+
+func TestTemplateValues(t *testing.T) {
+	// the template is not matched when only query values are decoded
+	v := &orderRequest{}
+	if err := UnmarshalValues(url.Values{"expand": {"true"}}, v); err != nil {
+		t.Fatal(err)
+	}
+	if eq, diff := trial.Equal(v, &orderRequest{Expand: true}); !eq {
+		t.Error(diff)
+	}
+
+	v = &orderRequest{}
+	if err := UnmarshalQuery("expand=true", v); err != nil {
+		t.Fatal(err)
+	}
+	if eq, diff := trial.Equal(v, &orderRequest{Expand: true}); !eq {
+		t.Error(diff)
+	}
+}
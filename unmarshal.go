@@ -3,87 +3,318 @@ package uri
 import (
 	"encoding"
 	"fmt"
+	"net/http"
 	"net/url"
 	"path/filepath"
 	"reflect"
-	"regexp"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
 	"unicode/utf8"
-
-	"github.com/jbsmith7741/go-tools/appenderr"
 )
 
-var regMapSplit = regexp.MustCompile("^(.*?)[:](.*)")
+// Decoder unmarshals uris into structs using its configured options.
+// A Decoder is safe for concurrent use.
+type Decoder struct {
+	opts  options
+	cache typeCache
+}
+
+// NewDecoder creates a Decoder with the provided options.
+// Without options the Decoder behaves the same as Unmarshal.
+func NewDecoder(opts ...Option) *Decoder {
+	return &Decoder{opts: newOptions(opts)}
+}
+
+var defaultDecoder = NewDecoder()
+
+// Unmarshaler is implemented by structs that decode themselves from the whole url.
+// It is called instead of decoding the struct field by field,
+// for the value passed to Unmarshal as well as for nested structs.
+// The url is a copy that includes all query values.
+type Unmarshaler interface {
+	UnmarshalURI(u *url.URL) error
+}
 
 // Unmarshal copies a standard parsable uri to a predefined struct
 // [scheme:][//[userinfo@]host][/]path[?query][#fragment]
 // scheme:opaque[?query][#fragment]
 func Unmarshal(uri string, v interface{}) error {
+	return defaultDecoder.Unmarshal(uri, v)
+}
+
+// Parse a uri into a new value of type T, see Unmarshal.
+// T must be a struct or a pointer to a struct, which go generics can not
+// enforce so other types return an InvalidUnmarshalError.
+func Parse[T any](uri string) (T, error) {
+	v, target := newTarget[T]()
+	err := Unmarshal(uri, target)
+	return *v, err
+}
+
+// MustParse is the same as Parse but panics on error.
+// It simplifies the initialization of package level variables.
+//
+//	var conf = uri.MustParse[Config]("https://localhost:8080?timeout=10s")
+func MustParse[T any](uri string) T {
+	v, err := Parse[T](uri)
+	if err != nil {
+		panic(err)
+	}
+	return v
+}
+
+// newTarget allocates a T and returns it with the value to unmarshal into.
+// When T is a pointer the value it points to is allocated as well.
+func newTarget[T any]() (*T, interface{}) {
+	v := new(T)
+	if t := reflect.TypeOf(*v); t != nil && t.Kind() == reflect.Ptr {
+		*v = reflect.New(t.Elem()).Interface().(T)
+		return v, *v
+	}
+	return v, v
+}
+
+// UnmarshalURL copies a parsed url to a predefined struct.
+// It is the same as Unmarshal without converting the url to a string and back.
+// The url is not modified.
+func UnmarshalURL(u *url.URL, v interface{}) error {
+	return defaultDecoder.UnmarshalURL(u, v)
+}
+
+// UnmarshalValues copies query values to a predefined struct, such as
+// the result of url.ParseQuery or an http.Request's Form.
+// Special keywords that are part of the url (scheme, host, path, etc) are left blank.
+func UnmarshalValues(values url.Values, v interface{}) error {
+	return defaultDecoder.UnmarshalValues(values, v)
+}
+
+// UnmarshalQuery is a comparable to the url.ParseQuery()
+func UnmarshalQuery(query string, v interface{}) error {
+	return defaultDecoder.UnmarshalQuery(query, v)
+}
+
+// SetField converts the string s to the type of value and sets the value if possible.
+// Pointers and slices are recursively dealt with by deferencing the pointer
+// or creating a generic slice of type value.
+// All structs and alias' that implement encoding.TextUnmarshaler are suppported
+func SetField(value reflect.Value, s string, sField reflect.StructField) error {
+	return defaultDecoder.SetField(value, s, sField)
+}
+
+// Unmarshal copies a standard parsable uri to a predefined struct
+// using the options of the Decoder. See Unmarshal
+func (d *Decoder) Unmarshal(uri string, v interface{}) error {
 	u, err := url.Parse(uri)
 	if err != nil {
 		return err
 	}
-	if strings.Contains(u.RawQuery, ";") {
-		u.RawQuery = strings.Replace(u.RawQuery, ";", "%3B", -1)
+	return d.UnmarshalURL(u, v)
+}
+
+// UnmarshalURL copies a parsed url to a predefined struct
+// using the options of the Decoder. See UnmarshalURL
+func (d *Decoder) UnmarshalURL(u *url.URL, v interface{}) error {
+	values, err := parseQuery(u.RawQuery)
+	if err != nil {
+		return err
 	}
-	values, err := url.ParseQuery(u.RawQuery)
+	return d.decode(&decodeState{u: u, values: values}, v)
+}
+
+// UnmarshalValues copies query values to a predefined struct
+// using the options of the Decoder. See UnmarshalValues
+func (d *Decoder) UnmarshalValues(values url.Values, v interface{}) error {
+	return d.decode(&decodeState{u: &url.URL{}, values: values, noURL: true}, v)
+}
+
+// parseQuery is url.ParseQuery that keeps semicolons as part of the value
+func parseQuery(query string) (url.Values, error) {
+	return url.ParseQuery(strings.Replace(query, ";", "%3B", -1))
+}
+
+// UnmarshalQuery is a comparable to the url.ParseQuery()
+// using the options of the Decoder.
+func (d *Decoder) UnmarshalQuery(query string, v interface{}) error {
+	values, err := parseQuery(query)
 	if err != nil {
 		return err
 	}
+	return d.UnmarshalValues(values, v)
+}
 
-	//verify that v is a pointer
-	if value := reflect.ValueOf(v); value.Kind() != reflect.Ptr || value.IsNil() {
-		return fmt.Errorf("%v must be a non nil pointer", reflect.TypeOf(v))
+// decodeState holds the parsed uri while its values are copied into a struct
+type decodeState struct {
+	u      *url.URL
+	values url.Values
+	header http.Header
+	noURL  bool              // only query values are decoded, the parts of the url are left blank
+	used   map[string]bool   // params that map to a struct field
+	params map[string]string // named segments of the path template
+	set    map[string]bool   // fields set by a non empty value, keyed like Meta
+
+	meta      Meta   // sources of the fields, only tracked by UnmarshalWithMeta
+	fieldPath string // field names of the nested struct being decoded, Range.
+	prefix    string // key of the nested struct, see NestedPrefix
+}
+
+// decode verifies v and copies the state into it
+func (d *Decoder) decode(s *decodeState, v interface{}) error {
+	//verify that v is a pointer to a struct
+	if value := reflect.ValueOf(v); value.Kind() != reflect.Ptr || value.IsNil() || value.Elem().Kind() != reflect.Struct {
+		return &InvalidUnmarshalError{Type: reflect.TypeOf(v)}
 	}
+	s.used = make(map[string]bool)
 
+	var errs MultiError
 	vStruct := reflect.ValueOf(v).Elem()
-	errs := appenderr.New()
-	for i := 0; i < vStruct.NumField(); i++ {
-		field := vStruct.Field(i)
+	if tmpl := lookupTemplate(vStruct.Type()); tmpl != nil && !s.noURL {
+		params, err := tmpl.match(s.u)
+		errs.add(err)
+		s.params = params
+	}
+	errs.add(d.unmarshal(s, vStruct))
+	if d.opts.disallowUnknown {
+		errs.add(s.unknownParams())
+	}
+	return errs.errOrNil()
+}
 
-		if !field.CanSet() { // skip private variables
-			continue
+// unknownParams returns an UnknownParamError for each param that was not used by a field
+func (s *decodeState) unknownParams() error {
+	var errs MultiError
+	keys := make([]string, 0, len(s.values))
+	for k := range s.values {
+		if !s.used[k] {
+			keys = append(keys, k)
 		}
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		errs.add(&UnknownParamError{Param: k})
+	}
+	return errs.errOrNil()
+}
 
-		name := vStruct.Type().Field(i).Name
-		tag := parseURITag(vStruct.Type().Field(i).Tag)
-		if tag == "-" {
-			continue
+// url returns a copy of the url with the query values of the state
+func (s *decodeState) url() *url.URL {
+	u := *s.u
+	u.RawQuery = s.values.Encode()
+	return &u
+}
+
+// present reports if the query has a non empty value for the param
+func (s *decodeState) present(name string) bool {
+	for _, v := range s.values[name] {
+		if v != "" {
+			return true
 		}
-		if tag != "" {
-			name = tag
-			tag = strings.ToLower(tag)
+	}
+	return false
+}
+
+// setField records that field f of the struct being decoded was set by the uri
+func (s *decodeState) setField(f *fieldInfo) {
+	if s.set == nil {
+		s.set = make(map[string]bool)
+	}
+	s.set[s.fieldPath+f.sField.Name] = true
+}
+
+// isSet reports if the param p of the struct being decoded set one of its fields.
+// Params that do not belong to a field are looked up in the query.
+func (s *decodeState) isSet(p string, fields []fieldInfo, o options) bool {
+	for i := range fields {
+		if fields[i].name == p {
+			return s.set[s.fieldPath+fields[i].sField.Name]
 		}
+	}
+	return s.present(o.key(s.prefix, p))
+}
+
+// useAll marks every param as used by a field
+func (s *decodeState) useAll() {
+	for k := range s.values {
+		s.used[k] = true
+	}
+}
+
+// unmarshal copies the state into vStruct between its before and after hooks
+func (d *Decoder) unmarshal(s *decodeState, vStruct reflect.Value) error {
+	beforeUnmarshal(vStruct)
+	var errs MultiError
+	errs.add(d.unmarshalFields(s, vStruct))
+	errs.add(afterUnmarshal(vStruct))
+	return errs.errOrNil()
+}
+
+func (d *Decoder) unmarshalFields(s *decodeState, vStruct reflect.Value) error {
+	if m, ok := vStruct.Addr().Interface().(Unmarshaler); ok {
+		// the params read by a custom unmarshaler are unknown
+		s.useAll()
+		return m.UnmarshalURI(s.url())
+	}
+	u, values := s.u, s.values
+	var errs MultiError
+	fields := d.fields(vStruct.Type())
+	for i := range fields {
+		f := &fields[i]
+		field := vStruct.Field(f.index)
+		name := d.opts.key(s.prefix, f.name)
 
 		// check default values
-		def := vStruct.Type().Field(i).Tag.Get(defaultTag)
-		if def != "" {
-			if err := SetField(field, def, vStruct.Type().Field(i)); err != nil {
-				errs.Add(fmt.Errorf("default value %s can not be set to %s (%s)", def, name, field.Type()))
+		if f.def != "" {
+			if err := f.set(field, f.def); err != nil {
+				errs.add(&DefaultError{Field: f.sField.Name, Param: name, Value: f.def, Type: field.Type(), Err: err})
+			} else {
+				s.mark(f, FromDefault)
 			}
 		}
 
-		skip, err := handleEmbeddeStruct(uri, field)
-		errs.Add(err)
+		skip, err := d.handleEmbeddeStruct(s, field, f)
+		errs.add(err)
 		if skip {
 			continue
 		}
+		errs.add(f.tagErr)
+		if f.structs {
+			if field.Kind() == reflect.Map {
+				errs.add(d.unmarshalStructMap(s, field, f))
+			} else {
+				errs.add(d.unmarshalStructs(s, field, f))
+			}
+			if field.Len() == 0 {
+				// like other fields, missing params are not validated
+				if f.required {
+					errs.add(&RequiredError{Field: f.sField.Name, Param: name})
+				}
+				continue
+			}
+			if err := f.validate(field); err != nil {
+				errs.add(fieldErrors(f, name, strconv.Itoa(field.Len()), err))
+			}
+			continue
+		}
 
-		required := vStruct.Type().Field(i).Tag.Get(requiredTag)
 		data := values.Get(name)
-		if field.Kind() == reflect.Slice {
-			data = strings.Join(values[name], sliceDelim)
+		if field.Kind() == reflect.Slice || field.Kind() == reflect.Array {
+			data = strings.Join(values[name], d.opts.sliceDelim)
 		}
 		if field.Kind() == reflect.Map {
-			data = strings.Join(values[name], mapDelim)
+			data = strings.Join(values[name], d.opts.mapDelim)
 		}
-		switch tag {
+		switch f.tag {
 		case scheme:
 			data = u.Scheme
 		case host:
 			data = u.Host
+		case hostname:
+			data = u.Hostname()
+		case port:
+			if data = u.Port(); data == "" && !(f.required && f.def == "") {
+				continue
+			}
 		case path:
 			data = u.Path
 		case userinfo:
@@ -94,6 +325,8 @@ func Unmarshal(uri string, v interface{}) error {
 			data, _ = u.User.Password()
 		case filename:
 			_, data = filepath.Split(u.Path)
+		case dir:
+			data, _ = filepath.Split(u.Path)
 		case origin:
 			data = fmt.Sprintf("%s://%s%s", u.Scheme, u.Host, u.Path)
 			if u.Scheme == "" && u.Host == "" {
@@ -107,198 +340,577 @@ func Unmarshal(uri string, v interface{}) error {
 		case fragment:
 			data = u.Fragment
 		default:
-			if len(values[name]) == 0 && !(required == "true" && def == "") {
+			if f.pos != nil {
+				parts, found := f.pos.get(u.Path)
+				if found && f.list != nil && f.pos.isRange {
+					s.setField(f)
+					if err := f.list(field, parts); err != nil {
+						errs.add(fieldErrors(f, name, strings.Join(parts, "/"), err))
+						continue
+					}
+					s.mark(f, FromURI)
+					if err := f.validate(field); err != nil {
+						errs.add(fieldErrors(f, name, strings.Join(parts, "/"), err))
+					}
+					continue
+				}
+				if !found && !(f.required && f.def == "") {
+					continue
+				}
+				data = strings.Join(parts, "/")
+				break
+			}
+			if f.param != "" {
+				var found bool
+				if data, found = s.params[f.param]; !found && !(f.required && f.def == "") {
+					continue
+				}
+				break
+			}
+			// the query param is used even when the header wins
+			s.used[name] = true
+			if h := s.header[f.header]; f.header != "" && len(h) > 0 {
+				data = h[0]
+				if field.Kind() == reflect.Slice || field.Kind() == reflect.Array {
+					data = strings.Join(h, d.opts.sliceDelim)
+				}
+				break
+			}
+			if (d.opts.brackets && f.list != nil) || (d.opts.keyedMaps() && f.pairs != nil) {
+				errs.add(d.unmarshalKeyed(s, field, f, name))
+				continue
+			}
+			if len(values[name]) == 0 && !(f.required && f.def == "") {
 				continue
 			}
 		}
 
-		if required == "true" && data == "" && def == "" {
-			errs.Addf("%s is required", name)
+		if f.required && data == "" && f.def == "" {
+			errs.add(&RequiredError{Field: f.sField.Name, Param: name})
 			continue
 		}
 
-		if err := SetField(field, data, vStruct.Type().Field(i)); err != nil {
-			errs.Wrapf(err, "%q can not be set to %s (%s)", data, name, field.Type())
+		// invalid values are still present for the conditional tags
+		if data != "" {
+			s.setField(f)
+		}
+		if err := f.set(field, data); err != nil {
+			errs.add(fieldErrors(f, name, data, err))
+			continue
+		}
+		if data != "" || len(values[name]) > 0 {
+			s.mark(f, FromURI)
+		}
+		if err := f.validate(field); err != nil {
+			errs.add(fieldErrors(f, name, data, err))
 		}
 	}
 
-	return errs.ErrOrNil()
+	// conditional rules depend on the params of other fields
+	for i := range fields {
+		if f := &fields[i]; f.cond != nil {
+			errs.add(f.cond.check(s, f, fields, d.opts))
+		}
+	}
+	return errs.errOrNil()
 }
 
-// UnmarshalQuery is a comparable to the url.ParseQuery()
-func UnmarshalQuery(query string, v interface{}) error {
-	u := url.URL{}
-	u.RawQuery = query
-	return Unmarshal(u.String(), v)
+func (d *Decoder) handleEmbeddeStruct(s *decodeState, value reflect.Value, f *fieldInfo) (bool, error) {
+	// structs that implement the TextUnmarshaler are not embedded and are parsed by SetField
+	if !f.embedded {
+		return false, nil
+	}
+	if !f.sField.Anonymous && (s.meta != nil || d.opts.prefixNested()) {
+		fieldPath, prefix := s.fieldPath, s.prefix
+		s.fieldPath += f.sField.Name + "."
+		if d.opts.prefixNested() {
+			s.prefix = d.opts.key(s.prefix, f.name)
+		}
+		defer func() { s.fieldPath, s.prefix = fieldPath, prefix }()
+	}
+	if value.Kind() == reflect.Struct {
+		return true, d.unmarshal(s, value)
+	}
+	if !value.IsNil() {
+		return true, d.unmarshal(s, value.Elem())
+	}
+	v := reflect.New(value.Type().Elem())
+	err := d.unmarshalFields(s, v.Elem())
+	// only set the pointer if values changed, otherwise keep it as nil
+	if reflect.DeepEqual(v.Elem().Interface(), reflect.Zero(v.Elem().Type()).Interface()) {
+		return true, err
+	}
+	if hasHooks(v.Elem().Type()) {
+		// decode again so the hooks see the struct from the start
+		v = reflect.New(value.Type().Elem())
+		err = d.unmarshal(s, v.Elem())
+	}
+	value.Set(v)
+	return true, err
 }
 
-func handleEmbeddeStruct(uri string, value reflect.Value) (bool, error) {
-	// do we have an embedded struct
-	switch value.Kind() {
-	case reflect.Struct:
-		v := value.Addr()
-		// if the struct implements the unmarshaler let SetField handle the parsing
-		if implementsUnmarshaler(v) {
-			return false, nil
+// unmarshalStructs decodes a slice of structs from indexed params, items[0].sku=a.
+// The indices are sorted and compacted so items[2] and items[5] become the first and second element.
+func (d *Decoder) unmarshalStructs(s *decodeState, value reflect.Value, f *fieldInfo) error {
+	var errs MultiError
+	base := d.opts.key(s.prefix, f.name)
+	prefix, sep := base+"[", d.opts.elemSep()
+	if d.opts.brackets {
+		sep = "["
+	}
+	found := make(map[int]bool)
+	largest := -1
+	for k := range s.values {
+		if !strings.HasPrefix(k, prefix) {
+			continue
 		}
-
-		err := Unmarshal(uri, v.Interface())
-		return true, err
-	case reflect.Ptr:
-		v := reflect.New(value.Type().Elem())
-		if v.Elem().Kind() != reflect.Struct {
-			return false, nil
+		end := strings.Index(k[len(prefix):], "]")
+		if end < 0 || !strings.HasPrefix(k[len(prefix)+end+1:], sep) {
+			continue
 		}
-		// if the struct implements the unmarshaler let SetField handle the parsing
-		if implementsUnmarshaler(value) {
-			return false, nil
+		i, err := strconv.Atoi(k[len(prefix) : len(prefix)+end])
+		if err != nil || i < 0 {
+			continue
 		}
-		if value.IsNil() {
-			err := Unmarshal(uri, v.Interface())
-			v2 := reflect.New(value.Type().Elem())
-			// only set the pointer if values changed, otherwise keep it as nil
-			if !reflect.DeepEqual(v.Interface(), v2.Interface()) {
-				value.Set(v)
+		if i > d.opts.maxIndex {
+			s.used[k] = true
+			if i > largest {
+				largest = i
 			}
-			return true, err
+			continue
 		}
-		err := Unmarshal(uri, value.Interface())
-		return true, err
+		found[i] = true
+	}
+	if largest >= 0 {
+		errs.add(&FieldError{
+			Field:  f.sField.Name,
+			Param:  base,
+			Value:  strconv.Itoa(largest),
+			Type:   f.sField.Type,
+			Reason: fmt.Sprintf("is greater than the max index %d", d.opts.maxIndex),
+		})
 	}
+	if len(found) == 0 {
+		return errs.errOrNil()
+	}
+	indices := make([]int, 0, len(found))
+	for i := range found {
+		indices = append(indices, i)
+	}
+	sort.Ints(indices)
 
-	return false, nil
+	t := f.sField.Type
+	list := reflect.MakeSlice(t, len(indices), len(indices))
+	parent, fieldPath := s.prefix, s.fieldPath
+	for j, i := range indices {
+		s.prefix = prefix + strconv.Itoa(i) + "]"
+		s.fieldPath = fieldPath + f.sField.Name + "[" + strconv.Itoa(j) + "]."
+		elem := list.Index(j)
+		if elem.Kind() == reflect.Ptr {
+			elem.Set(reflect.New(t.Elem().Elem()))
+			elem = elem.Elem()
+		}
+		errs.add(d.unmarshal(s, elem))
+	}
+	s.prefix, s.fieldPath = parent, fieldPath
+	value.Set(list)
+	s.mark(f, FromURI)
+	s.setField(f)
+	return errs.errOrNil()
 }
 
-// SetField converts the string s to the type of value and sets the value if possible.
-// Pointers and slices are recursively dealt with by deferencing the pointer
-// or creating a generic slice of type value.
-// All structs and alias' that implement encoding.TextUnmarshaler are suppported
-func SetField(value reflect.Value, s string, sField reflect.StructField) error {
-	if isAlias(value) {
-		v := reflect.New(value.Type())
-		if implementsUnmarshaler(v) {
-			err := v.Interface().(encoding.TextUnmarshaler).UnmarshalText([]byte(s))
-			if err != nil {
-				return err
+// unmarshalStructMap decodes a map of structs from keyed params, ?m.key.name=a or ?m[key][name]=a
+func (d *Decoder) unmarshalStructMap(s *decodeState, value reflect.Value, f *fieldInfo) error {
+	base := d.opts.key(s.prefix, f.name)
+	open, close := base+d.opts.elemSep(), d.opts.elemSep()
+	if d.opts.brackets {
+		open, close = base+"[", "]["
+	}
+	found := make(map[string]bool)
+	for k := range s.values {
+		if !strings.HasPrefix(k, open) {
+			continue
+		}
+		if i := strings.Index(k[len(open):], close); i > 0 {
+			found[k[len(open):len(open)+i]] = true
+		}
+	}
+	if len(found) == 0 {
+		return nil
+	}
+	keys := make([]string, 0, len(found))
+	for k := range found {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	t := f.sField.Type
+	if value.IsNil() {
+		value.Set(reflect.MakeMap(t))
+	}
+	kSet := d.setter(t.Key(), f.sField)
+	var errs MultiError
+	parent, fieldPath := s.prefix, s.fieldPath
+	for _, key := range keys {
+		kValue := reflect.New(t.Key()).Elem()
+		if err := kSet(kValue, key); err != nil {
+			errs.add(fieldErrors(f, base, key, &elemError{key: key, value: key, err: err}))
+			continue
+		}
+		s.prefix = d.opts.key(base, key)
+		s.fieldPath = fieldPath + f.sField.Name + "[" + key + "]."
+		elem := reflect.New(indirectType(t.Elem())).Elem()
+		errs.add(d.unmarshal(s, elem))
+		if t.Elem().Kind() == reflect.Ptr {
+			elem = elem.Addr()
+		}
+		value.SetMapIndex(kValue, elem)
+	}
+	s.prefix, s.fieldPath = parent, fieldPath
+	s.mark(f, FromURI)
+	s.setField(f)
+	return errs.errOrNil()
+}
+
+// entryKey returns the key of param k if it is an entry of the param name, m[key] or m.key
+func (d *Decoder) entryKey(name, k string) (string, bool) {
+	if d.opts.brackets {
+		if !strings.HasPrefix(k, name+"[") || !strings.HasSuffix(k, "]") || len(k) < len(name)+3 {
+			return "", false
+		}
+		key := k[len(name)+1 : len(k)-1]
+		return key, !strings.ContainsAny(key, "[]")
+	}
+	sep := d.opts.elemSep()
+	if !strings.HasPrefix(k, name+sep) || len(k) == len(name)+len(sep) {
+		return "", false
+	}
+	return k[len(name)+len(sep):], true
+}
+
+// unmarshalKeyed sets a slice from ?a[]=1&a[]=2 or a map from ?m[key]=v or ?m.key=v,
+// see Brackets and MapFormat. Indexed slice elements ?a[0]=1 are sorted by their index.
+func (d *Decoder) unmarshalKeyed(s *decodeState, value reflect.Value, f *fieldInfo, name string) error {
+	var data []string
+	var pairs [][2]string
+	indexed := make(map[int][]string)
+	largest := -1
+	for k, v := range s.values {
+		if k == name || (k == name+"[]" && d.opts.brackets) {
+			s.used[k] = true
+			data = append(data, v...)
+			continue
+		}
+		key, ok := d.entryKey(name, k)
+		if !ok {
+			continue
+		}
+		if f.pairs != nil {
+			s.used[k] = true
+			pairs = append(pairs, [2]string{key, strings.Join(v, d.opts.sliceDelim)})
+		} else if i, err := strconv.Atoi(key); err == nil && i >= 0 {
+			s.used[k] = true
+			if i > d.opts.maxIndex {
+				if i > largest {
+					largest = i
+				}
+				continue
 			}
-			value.Set(v.Elem())
-			return nil
+			indexed[i] = v
 		}
-		if value.Type() == reflect.TypeOf(time.Second) {
-			if d, err := time.ParseDuration(s); err == nil {
-				value.Set(reflect.ValueOf(d))
-				return nil
+	}
+	var errs MultiError
+	if largest >= 0 {
+		errs.add(&FieldError{
+			Field:  f.sField.Name,
+			Param:  name,
+			Value:  strconv.Itoa(largest),
+			Type:   f.sField.Type,
+			Reason: fmt.Sprintf("is greater than the max index %d", d.opts.maxIndex),
+		})
+	}
+	if len(indexed) > 0 {
+		keys := make([]int, 0, len(indexed))
+		for i := range indexed {
+			keys = append(keys, i)
+		}
+		sort.Ints(keys)
+		for _, i := range keys {
+			data = append(data, indexed[i]...)
+		}
+	}
+
+	var raw string
+	var err error
+	switch {
+	case f.pairs != nil && len(pairs)+len(data) > 0:
+		// joined pairs ?m=a:1|b:2 are set before the keyed params
+		sort.Slice(pairs, func(i, j int) bool { return pairs[i][0] < pairs[j][0] })
+		var joined [][2]string
+		for _, row := range data {
+			p, err := d.splitPairs(row)
+			if err != nil {
+				errs.add(fieldErrors(f, name, row, err))
+				return errs.errOrNil()
 			}
+			joined = append(joined, p...)
 		}
+		pairs = append(joined, pairs...)
+		rows := make([]string, len(pairs))
+		for i, kv := range pairs {
+			rows[i] = kv[0] + d.opts.keySep + kv[1]
+		}
+		raw = strings.Join(rows, d.opts.mapDelim)
+		err = f.pairs(value, pairs)
+	case f.pairs == nil && len(data) > 0:
+		raw = strings.Join(data, d.opts.sliceDelim)
+		err = f.list(value, data)
+	default:
+		if f.required && f.def == "" && largest < 0 {
+			errs.add(&RequiredError{Field: f.sField.Name, Param: name})
+		}
+		return errs.errOrNil()
+	}
+	if raw != "" {
+		s.setField(f)
 	}
-	switch value.Kind() {
+	if err != nil {
+		errs.add(fieldErrors(f, name, raw, err))
+		return errs.errOrNil()
+	}
+	s.mark(f, FromURI)
+	if err := f.validate(value); err != nil {
+		errs.add(fieldErrors(f, name, raw, err))
+	}
+	return errs.errOrNil()
+}
+
+// SetField converts the string s to the type of value and sets the value if possible
+// using the delimiters of the Decoder. See SetField
+func (d *Decoder) SetField(value reflect.Value, s string, sField reflect.StructField) error {
+	return d.setter(value.Type(), sField)(value, s)
+}
+
+// setFunc converts a string and assigns it to a value of a predetermined type
+type setFunc func(value reflect.Value, s string) error
+
+// setter builds the setFunc for type t. The reflection needed to determine how
+// t is parsed is done once so the returned func can be cached and reused.
+// Pointers, slices and maps are recursively dealt with by building setters for their elements.
+func (d *Decoder) setter(t reflect.Type, sField reflect.StructField) setFunc {
+	if isAliasType(t) {
+		if reflect.PtrTo(t).Implements(textUnmarshalerType) {
+			return setText
+		}
+		if t == durationType {
+			return setDuration
+		}
+	}
+	switch t.Kind() {
 	case reflect.String:
-		value.SetString(s)
+		return func(value reflect.Value, s string) error {
+			value.SetString(s)
+			return nil
+		}
 	case reflect.Bool:
-		b := strings.ToLower(s) == "true" || s == ""
-		value.SetBool(b)
-	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
-		i, err := strconv.ParseUint(s, 10, 0)
-		if err != nil {
-			return err
+		return func(value reflect.Value, s string) error {
+			value.SetBool(strings.ToLower(s) == "true" || s == "")
+			return nil
 		}
-		value.SetUint(i)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return setUint
 	case reflect.Int32:
 		if sField.Tag.Get("format") == "rune" {
-			r, _ := utf8.DecodeRuneInString(s)
-			value.Set(reflect.ValueOf(r))
-			return nil
+			return func(value reflect.Value, s string) error {
+				r, _ := utf8.DecodeRuneInString(s)
+				value.SetInt(int64(r))
+				return nil
+			}
 		}
-		fallthrough
+		return setInt
 	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int64:
-		i, err := strconv.ParseInt(s, 10, 0)
-		if err != nil {
-			return err
-		}
-		value.SetInt(i)
+		return setInt
 	case reflect.Float32, reflect.Float64:
-		f, err := strconv.ParseFloat(s, 0)
-		if err != nil {
-			return err
-		}
-		value.SetFloat(f)
+		return setFloat
 	case reflect.Ptr:
 		// create non pointer type and recursively assign
-		z := reflect.New(value.Type().Elem())
-		if s == "nil" {
-			return nil
-		}
-		SetField(z.Elem(), s, sField)
-		value.Set(z)
-	case reflect.Slice:
-		// create a generate slice and recursively assign the elements
-		baseType := reflect.TypeOf(value.Interface()).Elem()
-		if s == "" { // ignore empty slices
+		elem := d.setter(t.Elem(), sField)
+		return func(value reflect.Value, s string) error {
+			if s == "nil" {
+				return nil
+			}
+			z := reflect.New(t.Elem())
+			if err := elem(z.Elem(), s); err != nil && !d.opts.skipInvalid {
+				return err
+			}
+			value.Set(z)
 			return nil
 		}
-		data := strings.Split(s, sliceDelim)
-		slice := reflect.MakeSlice(value.Type(), 0, len(data))
-		for _, v := range data {
-			baseValue := reflect.New(baseType).Elem()
-			SetField(baseValue, v, sField)
-			slice = reflect.Append(slice, baseValue)
+	case reflect.Slice, reflect.Array:
+		// split into elements and assign them to the slice or array
+		list := d.listSetter(t, sField)
+		return func(value reflect.Value, s string) error {
+			if s == "" { // ignore empty slices
+				return nil
+			}
+			return list(value, strings.Split(s, d.opts.sliceDelim))
 		}
-		value.Set(slice)
 	case reflect.Struct:
-		v := reflect.New(value.Type())
-		if value.Type() == reflect.TypeOf(time.Time{}) {
+		if t == timeType {
 			format := sField.Tag.Get("format")
 			if format == "" {
 				format = time.RFC3339
 			}
-			t, err := time.Parse(format, s)
-			if err != nil {
-				return err
+			return func(value reflect.Value, s string) error {
+				t, err := time.Parse(format, s)
+				if err != nil {
+					return err
+				}
+				value.Set(reflect.ValueOf(t))
+				return nil
 			}
-			value.Set(reflect.ValueOf(t))
-			return nil
-
 		}
-		if implementsUnmarshaler(v) {
-			err := v.Interface().(encoding.TextUnmarshaler).UnmarshalText([]byte(s))
-			if err != nil {
-				return err
-			}
-			value.Set(v.Elem())
+		if reflect.PtrTo(t).Implements(textUnmarshalerType) {
+			return setText
 		}
+		return func(reflect.Value, string) error { return nil }
 	case reflect.Map:
-		// set map if nil
-		if value.IsNil() {
-			m := reflect.MakeMap(value.Type())
-			value.Set(m)
+		return d.mapSetter(t, sField)
+	default:
+		return func(value reflect.Value, _ string) error {
+			return fmt.Errorf("Unsupported type %v", value.Kind())
 		}
+	}
+}
 
-		// Type for map key anv value
-		kType := value.Type().Key()
-		vType := value.Type().Elem()
+// listFunc assigns a list of strings to the elements of a slice or array
+type listFunc func(value reflect.Value, data []string) error
 
-		// Split string into fields and key,value pairs
-		for _, row := range strings.Split(s, mapDelim) {
-			d := regMapSplit.FindStringSubmatch(row)
-			if len(d) != 3 {
-				return fmt.Errorf("invalid map format expected key:value got %v", row)
+// listSetter builds the listFunc for a slice or array type.
+// A generic slice of type t is created and the elements are recursively assigned,
+// arrays must be given exactly as many elements as their length.
+func (d *Decoder) listSetter(t reflect.Type, sField reflect.StructField) listFunc {
+	elem := d.setter(t.Elem(), sField)
+	return func(value reflect.Value, data []string) error {
+		var list reflect.Value
+		if t.Kind() == reflect.Array {
+			if len(data) != t.Len() {
+				return &lengthError{expected: t.Len(), got: len(data)}
 			}
-			k, v := d[1], d[2] // d[0] is match string
+			list = reflect.New(t).Elem()
+		} else {
+			list = reflect.MakeSlice(t, len(data), len(data))
+		}
+		var errs MultiError
+		for i, v := range data {
+			if err := elem(list.Index(i), v); err != nil && !d.opts.skipInvalid {
+				errs.add(&elemError{key: strconv.Itoa(i), value: v, err: err})
+			}
+		}
+		if len(errs) > 0 {
+			return errs
+		}
+		value.Set(list)
+		return nil
+	}
+}
+
+func (d *Decoder) mapSetter(t reflect.Type, sField reflect.StructField) setFunc {
+	set := d.pairsSetter(t, sField)
+	return func(value reflect.Value, s string) error {
+		pairs, err := d.splitPairs(s)
+		if err != nil {
+			return err
+		}
+		return set(value, pairs)
+	}
+}
+
+// splitPairs splits a string into key/value pairs, a:1|b:2
+func (d *Decoder) splitPairs(s string) ([][2]string, error) {
+	rows := strings.Split(s, d.opts.mapDelim)
+	pairs := make([][2]string, len(rows))
+	for i, row := range rows {
+		kv := strings.SplitN(row, d.opts.keySep, 2)
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("invalid map format expected key%svalue got %v", d.opts.keySep, row)
+		}
+		pairs[i] = [2]string{kv[0], kv[1]}
+	}
+	return pairs, nil
+}
+
+// pairsFunc assigns key/value pairs to a map of a predetermined type
+type pairsFunc func(value reflect.Value, pairs [][2]string) error
+
+// pairsSetter builds the pairsFunc for map type t
+func (d *Decoder) pairsSetter(t reflect.Type, sField reflect.StructField) pairsFunc {
+	// Type for map key anv value
+	kType, vType := t.Key(), t.Elem()
+	kSet, vSet := d.setter(kType, sField), d.setter(vType, sField)
+	return func(value reflect.Value, pairs [][2]string) error {
+		// set map if nil
+		if value.IsNil() {
+			value.Set(reflect.MakeMap(t))
+		}
+		for _, kv := range pairs {
 			// set key value
 			kValue := reflect.New(kType).Elem()
-			if err := SetField(kValue, k, sField); err != nil {
-				return err
+			if err := kSet(kValue, kv[0]); err != nil {
+				return &elemError{key: kv[0], value: kv[0], err: err}
 			}
 			// set value value
 			vValue := reflect.New(vType).Elem()
-			if err := SetField(vValue, v, sField); err != nil {
-				return err
+			if err := vSet(vValue, kv[1]); err != nil {
+				return &elemError{key: kv[0], value: kv[1], err: err}
 			}
 			// add key/value pair to map
 			value.SetMapIndex(kValue, vValue)
 		}
-	default:
-		return fmt.Errorf("Unsupported type %v", value.Kind())
+		return nil
+	}
+}
+
+func setText(value reflect.Value, s string) error {
+	v := reflect.New(value.Type())
+	if err := v.Interface().(encoding.TextUnmarshaler).UnmarshalText([]byte(s)); err != nil {
+		return err
+	}
+	value.Set(v.Elem())
+	return nil
+}
+
+func setDuration(value reflect.Value, s string) error {
+	if d, err := time.ParseDuration(s); err == nil {
+		value.SetInt(int64(d))
+		return nil
+	}
+	return setInt(value, s)
+}
+
+func setInt(value reflect.Value, s string) error {
+	i, err := strconv.ParseInt(s, 10, value.Type().Bits())
+	if err != nil {
+		return err
+	}
+	value.SetInt(i)
+	return nil
+}
+
+func setUint(value reflect.Value, s string) error {
+	i, err := strconv.ParseUint(s, 10, value.Type().Bits())
+	if err != nil {
+		return err
+	}
+	value.SetUint(i)
+	return nil
+}
+
+func setFloat(value reflect.Value, s string) error {
+	f, err := strconv.ParseFloat(s, 0)
+	if err != nil {
+		return err
 	}
+	value.SetFloat(f)
 	return nil
 }
@@ -1,8 +1,12 @@
 package uri
 
 import (
+	"errors"
 	"fmt"
+	"net/url"
 	"reflect"
+	"strconv"
+	"strings"
 	"testing"
 	"time"
 
@@ -58,6 +62,10 @@ type testStruct struct {
 	Floats64  []float64
 	TimeSlice []time.Time `format:"2006-01-02"`
 
+	// array
+	Array  [3]int
+	Coords [2]float64
+
 	// maps
 	MString map[string]string
 	MInt    map[int]int
@@ -266,6 +274,22 @@ func TestUnmarshal(t *testing.T) {
 				IntsP: []*int{trial.IntP(1), trial.IntP(2), nil, trial.IntP(3)},
 			},
 		},
+		"array": {
+			Input:    "?Array=1,2,3&Coords=1.5&Coords=-2.25",
+			Expected: &testStruct{Array: [3]int{1, 2, 3}, Coords: [2]float64{1.5, -2.25}},
+		},
+		"array too many elements": {
+			Input:       "?Array=1,2,3,4",
+			ExpectedErr: errors.New(`Array: "1,2,3,4" has 4 elements, [3]int requires 3`),
+		},
+		"array too few elements": {
+			Input:       "?Coords=1.5",
+			ExpectedErr: errors.New(`Coords: "1.5" has 1 elements, [2]float64 requires 2`),
+		},
+		"array invalid element": {
+			Input:       "?Array=1,a,3",
+			ExpectedErr: errors.New(`Array[1]: "a" is not an int`),
+		},
 		"empty slice": {
 			Input:    "?Ints=&Strings=",
 			Expected: &testStruct{},
@@ -365,6 +389,30 @@ func TestTags(t *testing.T) {
 				Host string `uri:"host"`
 			}{Host: "localhost:8080"},
 		},
+		"port and hostname": {
+			uri: "https://localhost:8080/usr/bin",
+			expected: &struct {
+				Hostname string `uri:"hostname"`
+				Port     int    `uri:"port"`
+				PortU    uint16 `uri:"port"`
+				PortS    string `uri:"port"`
+			}{Hostname: "localhost", Port: 8080, PortU: 8080, PortS: "8080"},
+		},
+		"missing port": {
+			uri: "https://localhost/usr/bin",
+			expected: &struct {
+				Host string `uri:"host"`
+				Port int    `uri:"port"`
+			}{Host: "localhost"},
+		},
+		"ipv6 hostname": {
+			uri: "http://[fe80::1%25en0]:8080/",
+			expected: &struct {
+				Host     string `uri:"host"`
+				Hostname string `uri:"hostname"`
+				Port     int    `uri:"port"`
+			}{Host: "[fe80::1%en0]:8080", Hostname: "fe80::1%en0", Port: 8080},
+		},
 		"Path uri tag": {
 			uri: "https://localhost:8080/usr/bin/file.txt",
 			expected: &struct {
@@ -375,6 +423,26 @@ func TestTags(t *testing.T) {
 				File: "file.txt",
 			},
 		},
+		"dir uri tag": {
+			uri: "s3://bucket/2024/01/02/file.gz",
+			expected: &struct {
+				Dir  string `uri:"dir"`
+				File string `uri:"filename"`
+			}{Dir: "/2024/01/02/", File: "file.gz"},
+		},
+		"positional path tags": {
+			uri: "s3://bucket/2024/01/02/file.gz",
+			expected: &struct {
+				Year  int      `uri:"path[0]"`
+				Month int      `uri:"path[1]"`
+				Day   int      `uri:"path[-2]"`
+				File  string   `uri:"path[-1]"`
+				Rest  []string `uri:"path[1:]"`
+				Date  string   `uri:"path[0:3]"`
+				Last  []string `uri:"path[-2:]"`
+				Out   string   `uri:"path[10]"`
+			}{Year: 2024, Month: 1, Day: 2, File: "file.gz", Rest: []string{"01", "02", "file.gz"}, Date: "2024/01/02", Last: []string{"02", "file.gz"}},
+		},
 		"Authority uri tag": {
 			uri: "https://localhost:8080/usr/bin",
 			expected: &struct {
@@ -586,6 +654,48 @@ func TestValidate(t *testing.T) {
 			data:      (*sliceDefault)(nil),
 			shouldErr: true,
 		},
+		"required port": {
+			uri: "http://localhost/",
+			data: &struct {
+				Port int `uri:"port" required:"true"`
+			}{},
+			shouldErr: true,
+		},
+		"port out of range": {
+			uri: "http://localhost:70000/",
+			data: &struct {
+				Port uint16 `uri:"port"`
+			}{},
+			shouldErr: true,
+		},
+		"int out of range": {
+			uri: "?i=200",
+			data: &struct {
+				I int8 `uri:"i"`
+			}{},
+			shouldErr: true,
+		},
+		"invalid path segment": {
+			uri: "/2024/jan",
+			data: &struct {
+				Month int `uri:"path[1]"`
+			}{},
+			shouldErr: true,
+		},
+		"required path segment": {
+			uri: "/2024",
+			data: &struct {
+				Month int `uri:"path[1]" required:"true"`
+			}{},
+			shouldErr: true,
+		},
+		"invalid path segment range": {
+			uri: "/1/2/c",
+			data: &struct {
+				Ints []int `uri:"path[0:]"`
+			}{},
+			shouldErr: true,
+		},
 	}
 	for name, test := range cases {
 		err := Unmarshal(test.uri, test.data)
@@ -596,3 +706,663 @@ func TestValidate(t *testing.T) {
 		}
 	}
 }
+
+func TestDecoder(t *testing.T) {
+	type data struct {
+		Ints  []int          `uri:"ints" qs:"i"`
+		Map   map[string]int `uri:"map" qs:"m"`
+		Value string         `json:"value"`
+	}
+	fn := func(args ...interface{}) (interface{}, error) {
+		d := NewDecoder(args[1].([]Option)...)
+		v := &data{}
+		err := d.Unmarshal(args[0].(string), v)
+		return v, err
+	}
+	cases := trial.Cases{
+		"default options": {
+			Input:    trial.Args("?ints=1,2&map=a:1|b:2&value=hello", []Option{}),
+			Expected: &data{Ints: []int{1, 2}, Map: map[string]int{"a": 1, "b": 2}, Value: "hello"},
+		},
+		"slice delim": {
+			Input:    trial.Args("?ints=1;2;3&ints=4", []Option{SliceDelim(";")}),
+			Expected: &data{Ints: []int{1, 2, 3, 4}},
+		},
+		"map delim and key sep": {
+			Input:    trial.Args("?map=a=1,b=2&map=c=3", []Option{MapDelim(","), KeySep("=")}),
+			Expected: &data{Map: map[string]int{"a": 1, "b": 2, "c": 3}},
+		},
+		"invalid key sep": {
+			Input:     trial.Args("?map=a:1", []Option{KeySep("=")}),
+			ShouldErr: true,
+		},
+		"tag name": {
+			Input:    trial.Args("?i=1,2&m=a:1&value=hello", []Option{TagName("qs")}),
+			Expected: &data{Ints: []int{1, 2}, Map: map[string]int{"a": 1}, Value: "hello"},
+		},
+		"without json tag": {
+			Input:    trial.Args("?value=hello&Value=world", []Option{JSONTag(false)}),
+			Expected: &data{Value: "world"},
+		},
+	}
+	trial.New(fn, cases).SubTest(t)
+}
+
+type benchStruct struct {
+	Scheme string            `uri:"scheme"`
+	Host   string            `uri:"host"`
+	Path   string            `uri:"path"`
+	Name   string            `uri:"name" required:"true"`
+	Limit  int               `uri:"limit" default:"10"`
+	Sort   string            `uri:"sort" default:"asc"`
+	Ids    []int             `uri:"id"`
+	Tags   map[string]string `uri:"tag"`
+	Start  time.Time         `uri:"start" format:"2006-01-02"`
+	Dura   time.Duration     `uri:"dura"`
+	Embedded
+}
+
+type Embedded struct {
+	Int    int
+	String string
+}
+
+func BenchmarkUnmarshal(b *testing.B) {
+	s := "https://localhost:8080/path/to/file.txt?name=hello&limit=20&id=1,2,3&tag=a:1|b:2&start=2020-01-02&dura=1h&Int=7&String=world"
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		var v benchStruct
+		if err := Unmarshal(s, &v); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkUnmarshalParallel(b *testing.B) {
+	s := "https://localhost:8080/path/to/file.txt?name=hello&limit=20&id=1,2,3&tag=a:1|b:2&start=2020-01-02&dura=1h&Int=7&String=world"
+	b.ReportAllocs()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			var v benchStruct
+			if err := Unmarshal(s, &v); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+func TestUnmarshalErrors(t *testing.T) {
+	type data struct {
+		Int   int       `uri:"int"`
+		Float float64   `uri:"float"`
+		Day   time.Time `uri:"day" format:"2006-01-02"`
+		Token string    `uri:"token" required:"true"`
+		Def   int       `uri:"def" default:"abc"`
+	}
+	fn := func(args ...interface{}) (interface{}, error) {
+		err := Unmarshal(args[0].(string), &data{})
+		var errs MultiError
+		if !errors.As(err, &errs) {
+			return nil, err
+		}
+		result := make([]string, 0)
+		for _, e := range errs {
+			switch v := e.(type) {
+			case *FieldError:
+				result = append(result, fmt.Sprintf("field %s %s %q %s", v.Field, v.Param, v.Value, v.Reason))
+			case *RequiredError:
+				result = append(result, fmt.Sprintf("required %s %s", v.Field, v.Param))
+			case *DefaultError:
+				result = append(result, fmt.Sprintf("default %s %s %q", v.Field, v.Param, v.Value))
+			default:
+				result = append(result, e.Error())
+			}
+		}
+		return result, nil
+	}
+	cases := trial.Cases{
+		"invalid values": {
+			Input: "?int=abc&float=1.2.3&day=2020&token=t",
+			Expected: []string{
+				`field Int int "abc" is not an int`,
+				`field Float float "1.2.3" is not a float`,
+				`field Day day "2020" does not match time format "2006-01-02"`,
+				`default Def def "abc"`,
+			},
+		},
+		"required": {
+			Input: "?int=99999999999999999999",
+			Expected: []string{
+				`field Int int "99999999999999999999" is out of range for int`,
+				`required Token token`,
+				`default Def def "abc"`,
+			},
+		},
+	}
+	trial.New(fn, cases).SubTest(t)
+}
+
+func TestMultiError(t *testing.T) {
+	err := Unmarshal("?int=a&token=", &struct {
+		Int   int    `uri:"int"`
+		Token string `uri:"token" required:"true"`
+	}{})
+	var fErr *FieldError
+	if !errors.As(err, &fErr) || fErr.Param != "int" {
+		t.Errorf("expected FieldError for int got %v", err)
+	}
+	var rErr *RequiredError
+	if !errors.As(err, &rErr) || rErr.Field != "Token" {
+		t.Errorf("expected RequiredError for Token got %v", err)
+	}
+	if !errors.Is(err, strconv.ErrSyntax) {
+		t.Errorf("expected %v to wrap strconv.ErrSyntax", err)
+	}
+	if s := err.Error(); s != "int: \"a\" is not an int\ntoken is required" {
+		t.Errorf("unexpected error message %q", s)
+	}
+
+	err = Unmarshal("", struct{}{})
+	var iErr *InvalidUnmarshalError
+	if !errors.As(err, &iErr) {
+		t.Errorf("expected InvalidUnmarshalError got %v", err)
+	}
+}
+
+func TestDisallowUnknownParams(t *testing.T) {
+	type data struct {
+		Path  string `uri:"path"`
+		Limit int    `uri:"limit" default:"10"`
+		Embedded
+		Struct *bStruct
+	}
+	d := NewDecoder(DisallowUnknownParams())
+	fn := func(args ...interface{}) (interface{}, error) {
+		v := &data{}
+		err := d.Unmarshal(args[0].(string), v)
+		return v, err
+	}
+	cases := trial.Cases{
+		"known params": {
+			Input:    "/a/b?limit=5&Int=1&String=s&Name=n",
+			Expected: &data{Path: "/a/b", Limit: 5, Embedded: Embedded{Int: 1, String: "s"}, Struct: &bStruct{Name: "n"}},
+		},
+		"typo": {
+			Input:       "?limt=5",
+			ExpectedErr: errors.New("unknown param limt"),
+		},
+		"keyword is not a param": {
+			Input:       "/a/b?path=c",
+			ExpectedErr: errors.New("unknown param path"),
+		},
+		"multiple": {
+			Input:       "?b=1&a=2&Int=3",
+			ExpectedErr: errors.New("unknown param a\nunknown param b"),
+		},
+	}
+	trial.New(fn, cases).SubTest(t)
+}
+
+func TestElementErrors(t *testing.T) {
+	type data struct {
+		Ints  []int            `uri:"ints"`
+		IntP  *int             `uri:"intp"`
+		IntsP []*int           `uri:"intsp"`
+		Map   map[string][]int `uri:"map"`
+	}
+	fn := func(args ...interface{}) (interface{}, error) {
+		v := &data{}
+		err := NewDecoder(args[1].([]Option)...).Unmarshal(args[0].(string), v)
+		return v, err
+	}
+	cases := trial.Cases{
+		"slice": {
+			Input:       trial.Args("?ints=1,x,3", []Option{}),
+			ExpectedErr: errors.New(`ints[1]: "x" is not an int`),
+		},
+		"slice with repeated keys": {
+			Input:       trial.Args("?ints=1&ints=2&ints=y", []Option{}),
+			ExpectedErr: errors.New(`ints[2]: "y" is not an int`),
+		},
+		"multiple elements": {
+			Input:       trial.Args("?ints=a,2,b", []Option{}),
+			ExpectedErr: errors.New("ints[0]: \"a\" is not an int\nints[2]: \"b\" is not an int"),
+		},
+		"pointer": {
+			Input:       trial.Args("?intp=x", []Option{}),
+			ExpectedErr: errors.New(`intp: "x" is not an int`),
+		},
+		"slice of pointers": {
+			Input:       trial.Args("?intsp=1,nil,z", []Option{}),
+			ExpectedErr: errors.New(`intsp[2]: "z" is not an int`),
+		},
+		"map of slices": {
+			Input:       trial.Args("?map=a:1,2|b:3,x", []Option{}),
+			ExpectedErr: errors.New(`map[b][1]: "x" is not an int`),
+		},
+		"lenient slice": {
+			Input:    trial.Args("?ints=1,x,3", []Option{SkipInvalidElements()}),
+			Expected: &data{Ints: []int{1, 0, 3}},
+		},
+		"lenient pointer": {
+			Input:    trial.Args("?intp=x", []Option{SkipInvalidElements()}),
+			Expected: &data{IntP: trial.IntP(0)},
+		},
+	}
+	trial.New(fn, cases).SubTest(t)
+}
+
+func TestParse(t *testing.T) {
+	type config struct {
+		Host    string        `uri:"host"`
+		Timeout time.Duration `uri:"timeout" default:"5s"`
+	}
+	cases := trial.Cases{
+		"struct": {
+			Input:    "http://localhost:8080?timeout=10s",
+			Expected: config{Host: "localhost:8080", Timeout: 10 * time.Second},
+		},
+		"invalid": {
+			Input:     "?timeout=abc",
+			ShouldErr: true,
+		},
+	}
+	trial.New(func(args ...interface{}) (interface{}, error) {
+		return Parse[config](args[0].(string))
+	}, cases).SubTest(t)
+
+	p, err := Parse[*config]("//localhost")
+	if err != nil || p == nil || p.Host != "localhost" || p.Timeout != 5*time.Second {
+		t.Errorf("Parse pointer: %v %+v", err, p)
+	}
+
+	var iErr *InvalidUnmarshalError
+	if _, err := Parse[int]("?a=1"); !errors.As(err, &iErr) {
+		t.Errorf("expected InvalidUnmarshalError for int got %v", err)
+	}
+}
+
+func TestMustParse(t *testing.T) {
+	v := MustParse[bStruct]("?Name=hello&Value=1")
+	if v.Name != "hello" || v.Value != 1 {
+		t.Errorf("unexpected value %+v", v)
+	}
+	defer func() {
+		if recover() == nil {
+			t.Error("expected MustParse to panic")
+		}
+	}()
+	MustParse[bStruct]("?Value=abc")
+}
+
+func TestUnmarshalURL(t *testing.T) {
+	u := &url.URL{Scheme: "https", Host: "localhost", Path: "/a/b", RawQuery: "String=hello;world&Ints=1,2"}
+	v := &testStruct{}
+	if err := UnmarshalURL(u, v); err != nil {
+		t.Fatal(err)
+	}
+	if eq, diff := trial.Equal(v, &testStruct{String: "hello;world", Ints: []int{1, 2}}); !eq {
+		t.Error(diff)
+	}
+	if u.RawQuery != "String=hello;world&Ints=1,2" {
+		t.Errorf("url was modified %q", u.RawQuery)
+	}
+}
+
+func TestUnmarshalValues(t *testing.T) {
+	fn := func(args ...interface{}) (interface{}, error) {
+		v := &struct {
+			Path  string   `uri:"path"`
+			Name  string   `uri:"name"`
+			Ints  []int    `uri:"ints"`
+			Limit int      `uri:"limit" default:"10"`
+			Tags  []string `uri:"tag"`
+		}{}
+		err := UnmarshalValues(args[0].(url.Values), v)
+		return v, err
+	}
+	cases := trial.Cases{
+		"values": {
+			Input: url.Values{"name": {"a;b"}, "ints": {"1", "2,3"}, "path": {"ignored"}},
+			Expected: &struct {
+				Path  string   `uri:"path"`
+				Name  string   `uri:"name"`
+				Ints  []int    `uri:"ints"`
+				Limit int      `uri:"limit" default:"10"`
+				Tags  []string `uri:"tag"`
+			}{Name: "a;b", Ints: []int{1, 2, 3}, Limit: 10},
+		},
+		"invalid": {
+			Input:     url.Values{"limit": {"x"}},
+			ShouldErr: true,
+		},
+	}
+	trial.New(fn, cases).SubTest(t)
+}
+
+// bucket is read from the scheme, host and path s3://bucket/prefix
+type bucket struct {
+	Name   string
+	Prefix string
+}
+
+func (b *bucket) UnmarshalURI(u *url.URL) error {
+	if u.Scheme != "s3" {
+		return errors.New("bucket requires s3 scheme")
+	}
+	b.Name = u.Host
+	b.Prefix = strings.TrimPrefix(u.Path, "/")
+	return nil
+}
+
+func (b bucket) MarshalURI() (*url.URL, error) {
+	if b.Name == "" {
+		return nil, errors.New("bucket name is required")
+	}
+	return &url.URL{Scheme: "s3", Host: b.Name, Path: "/" + b.Prefix}, nil
+}
+
+type bucketConfig struct {
+	Bucket  bucket
+	Backup  *bucket
+	Region  string `uri:"region"`
+	Workers int    `uri:"workers"`
+}
+
+func TestUnmarshaler(t *testing.T) {
+	fn := func(args ...interface{}) (interface{}, error) {
+		v := &bucketConfig{}
+		err := NewDecoder(DisallowUnknownParams()).Unmarshal(args[0].(string), v)
+		return v, err
+	}
+	cases := trial.Cases{
+		"nested": {
+			Input: "s3://data/logs/2020?region=us-east-1&workers=4",
+			Expected: &bucketConfig{
+				Bucket:  bucket{Name: "data", Prefix: "logs/2020"},
+				Backup:  &bucket{Name: "data", Prefix: "logs/2020"},
+				Region:  "us-east-1",
+				Workers: 4,
+			},
+		},
+		"params used by unmarshaler": {
+			Input: "s3://data?extra=1",
+			Expected: &bucketConfig{
+				Bucket: bucket{Name: "data"},
+				Backup: &bucket{Name: "data"},
+			},
+		},
+		"error": {
+			Input:       "gs://data?workers=4",
+			ExpectedErr: errors.New("bucket requires s3 scheme"),
+		},
+	}
+	trial.New(fn, cases).SubTest(t)
+
+	// the unmarshaler is used for the top level struct
+	b := &bucket{}
+	if err := Unmarshal("s3://data/logs?Name=ignored", b); err != nil {
+		t.Fatal(err)
+	}
+	if eq, diff := trial.Equal(b, &bucket{Name: "data", Prefix: "logs"}); !eq {
+		t.Error(diff)
+	}
+}
+
+type nestedFilter struct {
+	Name string `uri:"name"`
+	Age  int    `uri:"age"`
+}
+
+type nestedQuery struct {
+	Name   string        `uri:"name"`
+	Filter nestedFilter  `uri:"filter"`
+	Owner  *nestedFilter `uri:"owner"`
+	Embedded
+}
+
+func TestNestedPrefix(t *testing.T) {
+	fn := func(args ...interface{}) (interface{}, error) {
+		v := &nestedQuery{}
+		err := NewDecoder(args[1].(Option)).Unmarshal(args[0].(string), v)
+		return v, err
+	}
+	cases := trial.Cases{
+		"dot": {
+			Input: trial.Args("?name=a&filter.name=b&filter.age=3&owner.name=c&Int=1", NestedPrefix(".")),
+			Expected: &nestedQuery{
+				Name:     "a",
+				Filter:   nestedFilter{Name: "b", Age: 3},
+				Owner:    &nestedFilter{Name: "c"},
+				Embedded: Embedded{Int: 1},
+			},
+		},
+		"underscore": {
+			Input:    trial.Args("?filter_age=3", NestedPrefix("_")),
+			Expected: &nestedQuery{Filter: nestedFilter{Age: 3}},
+		},
+		"flat": {
+			Input:    trial.Args("?name=a&age=3", NestedPrefix("")),
+			Expected: &nestedQuery{Name: "a", Filter: nestedFilter{Name: "a", Age: 3}, Owner: &nestedFilter{Name: "a", Age: 3}},
+		},
+		"errors": {
+			Input:       trial.Args("?filter.age=x", NestedPrefix(".")),
+			ExpectedErr: errors.New(`filter.age: "x" is not an int`),
+		},
+	}
+	trial.New(fn, cases).SubTest(t)
+}
+
+type orderItems struct {
+	Items []bStruct  `uri:"items"`
+	Ptrs  []*bStruct `uri:"ptrs"`
+	Note  string     `uri:"note"`
+}
+
+func TestStructSlice(t *testing.T) {
+	fn := func(args ...interface{}) (interface{}, error) {
+		v := &orderItems{}
+		err := NewDecoder(MaxIndex(10), DisallowUnknownParams()).Unmarshal(args[0].(string), v)
+		return v, err
+	}
+	cases := trial.Cases{
+		"items": {
+			Input:    "?items[0].Name=a&items[0].Value=2&items[1].Name=b",
+			Expected: &orderItems{Items: []bStruct{{Name: "a", Value: 2}, {Name: "b"}}},
+		},
+		"escaped": {
+			Input:    "?items%5B0%5D.Name=a",
+			Expected: &orderItems{Items: []bStruct{{Name: "a"}}},
+		},
+		"pointers": {
+			Input:    "?ptrs[1].Value=3",
+			Expected: &orderItems{Ptrs: []*bStruct{{Value: 3}}},
+		},
+		"sparse indices are compacted": {
+			Input:    "?items[7].Name=c&items[2].Name=a&items[10].Name=d",
+			Expected: &orderItems{Items: []bStruct{{Name: "a"}, {Name: "c"}, {Name: "d"}}},
+		},
+		"max index": {
+			Input:       "?items[0].Name=a&items[11].Name=b&items[1000000].Name=c",
+			ExpectedErr: errors.New(`items: "1000000" is greater than the max index 10`),
+		},
+		"element errors": {
+			Input:       "?items[3].Value=x",
+			ExpectedErr: errors.New(`items[3].Value: "x" is not an int`),
+		},
+		"unknown element param": {
+			Input:       "?items[0].Name=a&items[0].Size=2&items[x].Name=b",
+			ExpectedErr: errors.New("unknown param items[0].Size\nunknown param items[x].Name"),
+		},
+	}
+	trial.New(fn, cases).SubTest(t)
+
+	// elements use the separator of NestedPrefix
+	v := &orderItems{}
+	if err := NewDecoder(NestedPrefix("_")).Unmarshal("?items[0]_Name=a", v); err != nil {
+		t.Fatal(err)
+	}
+	if eq, diff := trial.Equal(v, &orderItems{Items: []bStruct{{Name: "a"}}}); !eq {
+		t.Error(diff)
+	}
+}
+
+type requiredItems struct {
+	Items []bStruct          `uri:"items" required:"true" maxlen:"1"`
+	Users map[string]bStruct `uri:"users" minlen:"2"`
+}
+
+func TestStructSliceTags(t *testing.T) {
+	fn := func(args ...interface{}) (interface{}, error) {
+		v := &requiredItems{}
+		err := Unmarshal(args[0].(string), v)
+		return v, err
+	}
+	cases := trial.Cases{
+		"valid": {
+			Input:    "?items[0].Name=a",
+			Expected: &requiredItems{Items: []bStruct{{Name: "a"}}},
+		},
+		"required": {
+			Input:       "?",
+			ExpectedErr: errors.New("items is required"),
+		},
+		"maxlen": {
+			Input:       "?items[0].Name=a&items[1].Name=b",
+			ExpectedErr: errors.New(`items: "2" must have a length of at most 1`),
+		},
+		"minlen of map": {
+			Input:       "?items[0].Name=a&users.a.Name=b",
+			ExpectedErr: errors.New(`users: "1" must have a length of at least 2`),
+		},
+	}
+	trial.New(fn, cases).SubTest(t)
+
+	// invalid tags are reported for slices of structs
+	err := Unmarshal("?", &struct {
+		Items []bStruct `uri:"items" max:"2"`
+	}{})
+	if err == nil || !strings.Contains(err.Error(), "invalid max tag") {
+		t.Errorf("expected tag error got %v", err)
+	}
+}
+
+type qsFilter struct {
+	Status string       `uri:"status"`
+	Owner  nestedFilter `uri:"owner"`
+}
+
+type qsQuery struct {
+	IDs    []int          `uri:"ids"`
+	Tags   []string       `uri:"tag"`
+	Labels map[string]int `uri:"labels"`
+	Filter qsFilter       `uri:"filter"`
+	Items  []bStruct      `uri:"items"`
+	Page   int            `uri:"page"`
+}
+
+func TestBrackets(t *testing.T) {
+	fn := func(args ...interface{}) (interface{}, error) {
+		v := &qsQuery{}
+		err := NewDecoder(Brackets(true), DisallowUnknownParams()).Unmarshal(args[0].(string), v)
+		return v, err
+	}
+	cases := trial.Cases{
+		"slices": {
+			Input:    "?ids[]=1&ids[]=2&tag=a,b&tag=c",
+			Expected: &qsQuery{IDs: []int{1, 2}, Tags: []string{"a,b", "c"}},
+		},
+		"indexed slices": {
+			Input:    "?ids[1]=2&ids[0]=1&ids[]=0",
+			Expected: &qsQuery{IDs: []int{0, 1, 2}},
+		},
+		"escaped": {
+			Input:    "?ids%5B%5D=1&labels%5Ba%5D=2",
+			Expected: &qsQuery{IDs: []int{1}, Labels: map[string]int{"a": 2}},
+		},
+		"maps": {
+			Input:    "?labels[a]=1&labels[b:c]=2&page=3",
+			Expected: &qsQuery{Labels: map[string]int{"a": 1, "b:c": 2}, Page: 3},
+		},
+		"nested structs": {
+			Input:    "?filter[status]=open&filter[owner][name]=me&filter[owner][age]=3",
+			Expected: &qsQuery{Filter: qsFilter{Status: "open", Owner: nestedFilter{Name: "me", Age: 3}}},
+		},
+		"slice of structs": {
+			Input:    "?items[0][Name]=a&items[1][Value]=2",
+			Expected: &qsQuery{Items: []bStruct{{Name: "a"}, {Value: 2}}},
+		},
+		"max index": {
+			Input:       "?ids[0]=1&tag[500]=x&tag[101]=y",
+			ExpectedErr: errors.New(`tag: "500" is greater than the max index 100`),
+		},
+		"element errors": {
+			Input:       "?ids[]=1&ids[]=x&labels[a]=y",
+			ExpectedErr: errors.New("ids[1]: \"x\" is not an int\nlabels[a]: \"y\" is not an int"),
+		},
+		"unknown": {
+			Input:       "?labels[a][b]=1&filter[name]=x",
+			ExpectedErr: errors.New("unknown param filter[name]\nunknown param labels[a][b]"),
+		},
+	}
+	trial.New(fn, cases).SubTest(t)
+}
+
+type mapQuery struct {
+	Labels map[string]int      `uri:"labels"`
+	Groups map[string][]string `uri:"groups"`
+	Users  map[string]bStruct  `uri:"users"`
+	Ptrs   map[int]*bStruct    `uri:"ptrs"`
+	Filter nestedFilter        `uri:"filter"`
+}
+
+func TestMapFormat(t *testing.T) {
+	fn := func(args ...interface{}) (interface{}, error) {
+		v := &mapQuery{}
+		opts := []Option{DisallowUnknownParams()}
+		for _, o := range args[1:] {
+			opts = append(opts, o.(Option))
+		}
+		err := NewDecoder(opts...).Unmarshal(args[0].(string), v)
+		return v, err
+	}
+	cases := trial.Cases{
+		"repeated": {
+			Input:    trial.Args("?labels=a:1&labels=b:2|c:3"),
+			Expected: &mapQuery{Labels: map[string]int{"a": 1, "b": 2, "c": 3}},
+		},
+		"keys": {
+			Input:    trial.Args("?labels.a=1&labels.b.c=2&labels=d:4&groups.x=1,2", MapFormat(MapKeys)),
+			Expected: &mapQuery{Labels: map[string]int{"a": 1, "b.c": 2, "d": 4}, Groups: map[string][]string{"x": {"1", "2"}}},
+		},
+		"keys with separator": {
+			Input:    trial.Args("?labels_a=1", MapFormat(MapKeys), NestedPrefix("_")),
+			Expected: &mapQuery{Labels: map[string]int{"a": 1}},
+		},
+		"keys are unknown without MapKeys": {
+			Input:       trial.Args("?labels.a=1"),
+			ExpectedErr: errors.New("unknown param labels.a"),
+		},
+		"invalid key value": {
+			Input:       trial.Args("?labels.a=x", MapFormat(MapKeys)),
+			ExpectedErr: errors.New(`labels[a]: "x" is not an int`),
+		},
+		"map of structs": {
+			Input:    trial.Args("?users.bob.Name=Bob&users.bob.Value=2&users.amy.Value=1&ptrs.3.Name=c"),
+			Expected: &mapQuery{Users: map[string]bStruct{"bob": {Name: "Bob", Value: 2}, "amy": {Value: 1}}, Ptrs: map[int]*bStruct{3: {Name: "c"}}},
+		},
+		"map of structs with brackets": {
+			Input:    trial.Args("?users[bob][Name]=Bob&ptrs[3][Value]=1", Brackets(true)),
+			Expected: &mapQuery{Users: map[string]bStruct{"bob": {Name: "Bob"}}, Ptrs: map[int]*bStruct{3: {Value: 1}}},
+		},
+		"map of structs invalid key": {
+			Input:       trial.Args("?ptrs.x.Name=c"),
+			ExpectedErr: errors.New(`ptrs[x]: "x" is not an int`),
+		},
+		"map of structs unknown field": {
+			Input:       trial.Args("?users.bob.Age=3"),
+			ExpectedErr: errors.New("unknown param users.bob.Age"),
+		},
+	}
+	trial.New(fn, cases).SubTest(t)
+}
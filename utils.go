@@ -5,32 +5,60 @@ import (
 	"fmt"
 	"reflect"
 	"strings"
+	"time"
 )
 
-func isAlias(v reflect.Value) bool {
-	if v.Kind() == reflect.Struct || v.Kind() == reflect.Ptr {
+var (
+	textUnmarshalerType = reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem()
+	textMarshalerType   = reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()
+	stringerType        = reflect.TypeOf((*fmt.Stringer)(nil)).Elem()
+	unmarshalerType     = reflect.TypeOf((*Unmarshaler)(nil)).Elem()
+	marshalerType       = reflect.TypeOf((*Marshaler)(nil)).Elem()
+	timeType            = reflect.TypeOf(time.Time{})
+	durationType        = reflect.TypeOf(time.Second)
+)
+
+func isAliasType(t reflect.Type) bool {
+	if t.Kind() == reflect.Struct || t.Kind() == reflect.Ptr {
 		return false
 	}
-	return strings.Contains(v.Type().String(), ".")
+	return strings.Contains(t.String(), ".")
 }
 
-func implementsUnmarshaler(v reflect.Value) bool {
-	return v.Type().Implements(reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem())
+// implementsURI reports if t is a struct or *struct that implements
+// the iface with either a value or a pointer receiver
+func implementsURI(t reflect.Type, iface reflect.Type) bool {
+	switch t.Kind() {
+	case reflect.Struct:
+		return reflect.PtrTo(t).Implements(iface)
+	case reflect.Ptr:
+		return t.Elem().Kind() == reflect.Struct && t.Implements(iface)
+	}
+	return false
 }
 
 func implementsMarshaler(v reflect.Value) bool {
-	return v.Type().Implements(reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem())
+	return v.Type().Implements(textMarshalerType)
 }
 
 func tryMarshal(v reflect.Value) (string, error) {
+	// methods with a pointer receiver are called on an addressable copy
+	if !implementsMarshaler(v) && reflect.PtrTo(v.Type()).Implements(textMarshalerType) {
+		if !v.CanAddr() {
+			p := reflect.New(v.Type())
+			p.Elem().Set(v)
+			v = p.Elem()
+		}
+		v = v.Addr()
+	}
 	// does it implement TextMarshaler?
 	if implementsMarshaler(v) {
 		b, err := v.Interface().(encoding.TextMarshaler).MarshalText()
 		return string(b), err
-	} else if v.Type().Implements(reflect.TypeOf((*fmt.Stringer)(nil)).Elem()) {
+	} else if v.Type().Implements(stringerType) {
 		return v.Interface().(fmt.Stringer).String(), nil
 	}
-	return "", nil
+	return "", &UnsupportedTypeError{Type: v.Type()}
 }
 
 func isZero(v reflect.Value) bool {
@@ -51,12 +79,3 @@ func isZero(v reflect.Value) bool {
 	z := reflect.Zero(v.Type())
 	return v.Interface() == z.Interface()
 }
-
-// parseURITag gets structTag field from uriTag or jsonTag.
-// If the jsonTag value is found the only the value before the comma is returned.
-func parseURITag(v reflect.StructTag) string {
-	if tag := v.Get(uriTag); tag != "" {
-		return tag
-	}
-	return strings.Split(v.Get(jsonTag), ",")[0]
-}
@@ -0,0 +1,313 @@
+package uri
+
+import (
+	"fmt"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// supported validation tags
+const (
+	minTag     = "min"     // numbers, durations and times
+	maxTag     = "max"     // numbers, durations and times
+	minLenTag  = "minlen"  // strings, slices, arrays and maps
+	maxLenTag  = "maxlen"  // strings, slices, arrays and maps
+	enumTag    = "enum"    // comma separated list of allowed values
+	patternTag = "pattern" // regular expression for strings
+)
+
+// checkFunc verifies a value after it has been set
+type checkFunc func(value reflect.Value) error
+
+// checker builds the checkFunc for the validation tags of sField.
+// The length rules apply to the field, the other rules apply to each element of a slice or array.
+// nil is returned if the field does not have any validation tags.
+func (d *Decoder) checker(sField reflect.StructField) (checkFunc, error) {
+	t := indirectType(sField.Type)
+	var checks []checkFunc
+	for _, rule := range []string{minLenTag, maxLenTag} {
+		limit := sField.Tag.Get(rule)
+		if limit == "" {
+			continue
+		}
+		c, err := lengthCheck(rule, limit, t)
+		if err != nil {
+			return nil, &TagError{Field: sField.Name, Tag: rule, Value: limit, Err: err}
+		}
+		checks = append(checks, c)
+	}
+
+	elem := t
+	if t.Kind() == reflect.Slice || t.Kind() == reflect.Array {
+		elem = indirectType(t.Elem())
+	}
+	var elemChecks []checkFunc
+	for _, rule := range []string{minTag, maxTag, enumTag, patternTag} {
+		limit := sField.Tag.Get(rule)
+		if limit == "" {
+			continue
+		}
+		var c checkFunc
+		var err error
+		switch rule {
+		case minTag, maxTag:
+			c, err = d.boundCheck(rule, limit, elem, sField)
+		case enumTag:
+			c, err = d.enumCheck(limit, elem, sField)
+		case patternTag:
+			c, err = patternCheck(limit, elem)
+		}
+		if err != nil {
+			return nil, &TagError{Field: sField.Name, Tag: rule, Value: limit, Err: err}
+		}
+		elemChecks = append(elemChecks, c)
+	}
+	if len(elemChecks) > 0 {
+		c := allChecks(elemChecks)
+		if elem != t {
+			c = eachElem(c, sField.Tag)
+		}
+		checks = append(checks, c)
+	}
+
+	if len(checks) == 0 {
+		return nil, nil
+	}
+	return allChecks(checks), nil
+}
+
+// allChecks combines checks into one that returns the errors of every failing rule.
+// nil pointers are not checked.
+func allChecks(checks []checkFunc) checkFunc {
+	return func(value reflect.Value) error {
+		value, ok := indirect(value)
+		if !ok {
+			return nil
+		}
+		var errs MultiError
+		for _, c := range checks {
+			errs.add(c(value))
+		}
+		return errs.errOrNil()
+	}
+}
+
+// eachElem applies the check to each element of a slice or array
+func eachElem(check checkFunc, sTag reflect.StructTag) checkFunc {
+	return func(value reflect.Value) error {
+		var errs MultiError
+		for i := 0; i < value.Len(); i++ {
+			if err := check(value.Index(i)); err != nil {
+				s, _ := defaultEncoder.fieldString(value.Index(i), sTag)
+				errs.add(&elemError{key: strconv.Itoa(i), value: s, err: err})
+			}
+		}
+		return errs.errOrNil()
+	}
+}
+
+func lengthCheck(rule, limit string, t reflect.Type) (checkFunc, error) {
+	n, err := strconv.Atoi(limit)
+	if err != nil || n < 0 {
+		return nil, fmt.Errorf("%s is not a valid length", limit)
+	}
+	switch t.Kind() {
+	case reflect.String, reflect.Slice, reflect.Array, reflect.Map:
+	default:
+		return nil, fmt.Errorf("%v does not have a length", t)
+	}
+	return func(value reflect.Value) error {
+		l := value.Len()
+		if value.Kind() == reflect.String {
+			l = len([]rune(value.String()))
+		}
+		if (rule == minLenTag && l < n) || (rule == maxLenTag && l > n) {
+			return &ValidationError{Rule: rule, Limit: limit}
+		}
+		return nil
+	}, nil
+}
+
+// boundCheck compares numbers, durations and times with the limit
+// converted to the same type as the field
+func (d *Decoder) boundCheck(rule, limit string, t reflect.Type, sField reflect.StructField) (checkFunc, error) {
+	var cmp func(v, l reflect.Value) int
+	switch t.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		cmp = func(v, l reflect.Value) int { return compare(v.Int() < l.Int(), v.Int() > l.Int()) }
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		cmp = func(v, l reflect.Value) int { return compare(v.Uint() < l.Uint(), v.Uint() > l.Uint()) }
+	case reflect.Float32, reflect.Float64:
+		cmp = func(v, l reflect.Value) int { return compare(v.Float() < l.Float(), v.Float() > l.Float()) }
+	default:
+		if t != timeType {
+			return nil, fmt.Errorf("%v is not a number, duration or time", t)
+		}
+		cmp = func(v, l reflect.Value) int {
+			a, b := v.Interface().(time.Time), l.Interface().(time.Time)
+			return compare(a.Before(b), a.After(b))
+		}
+	}
+	l := reflect.New(t).Elem()
+	if err := d.setter(t, sField)(l, limit); err != nil {
+		return nil, err
+	}
+	return func(value reflect.Value) error {
+		c := cmp(value, l)
+		if (rule == minTag && c < 0) || (rule == maxTag && c > 0) {
+			return &ValidationError{Rule: rule, Limit: limit}
+		}
+		return nil
+	}, nil
+}
+
+func compare(less, greater bool) int {
+	if less {
+		return -1
+	}
+	if greater {
+		return 1
+	}
+	return 0
+}
+
+// enumCheck converts each of the allowed values to type t
+func (d *Decoder) enumCheck(limit string, t reflect.Type, sField reflect.StructField) (checkFunc, error) {
+	set := d.setter(t, sField)
+	allowed := make([]interface{}, 0)
+	for _, s := range strings.Split(limit, ",") {
+		v := reflect.New(t).Elem()
+		if err := set(v, strings.TrimSpace(s)); err != nil {
+			return nil, err
+		}
+		allowed = append(allowed, v.Interface())
+	}
+	return func(value reflect.Value) error {
+		v := value.Interface()
+		for _, a := range allowed {
+			if reflect.DeepEqual(v, a) {
+				return nil
+			}
+		}
+		return &ValidationError{Rule: enumTag, Limit: limit}
+	}, nil
+}
+
+func patternCheck(limit string, t reflect.Type) (checkFunc, error) {
+	if t.Kind() != reflect.String {
+		return nil, fmt.Errorf("%v is not a string", t)
+	}
+	reg, err := regexp.Compile(limit)
+	if err != nil {
+		return nil, err
+	}
+	return func(value reflect.Value) error {
+		if !reg.MatchString(value.String()) {
+			return &ValidationError{Rule: patternTag, Limit: limit}
+		}
+		return nil
+	}, nil
+}
+
+// indirectType returns the type that t points to
+func indirectType(t reflect.Type) reflect.Type {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	return t
+}
+
+// indirect returns the value that v points to, false is returned for nil pointers
+func indirect(v reflect.Value) (reflect.Value, bool) {
+	for v.Kind() == reflect.Ptr {
+		if v.IsNil() {
+			return v, false
+		}
+		v = v.Elem()
+	}
+	return v, true
+}
+
+// supported conditional tags, the values refer to other params by their uri name
+const (
+	requiredIfTag   = "required_if"   // required_if:"type=card"
+	requiredWithTag = "required_with" // required_with:"start"
+	excludedWithTag = "excluded_with" // excluded_with:"page"
+)
+
+// conditions of a field that depend on which params are present
+type conditions struct {
+	requiredIf   [][2]string // param=value pairs that must all match
+	requiredWith []string    // any of the params
+	excludedWith []string    // any of the params
+}
+
+// parseConditions returns the conditional tags of sField,
+// nil is returned if the field does not have any.
+func parseConditions(sField reflect.StructField) (*conditions, error) {
+	c := &conditions{
+		requiredWith: splitParams(sField.Tag.Get(requiredWithTag)),
+		excludedWith: splitParams(sField.Tag.Get(excludedWithTag)),
+	}
+	tag := sField.Tag.Get(requiredIfTag)
+	for _, s := range splitParams(tag) {
+		kv := strings.SplitN(s, "=", 2)
+		if len(kv) != 2 || kv[0] == "" {
+			return nil, &TagError{Field: sField.Name, Tag: requiredIfTag, Value: tag, Err: fmt.Errorf("expected param=value got %s", s)}
+		}
+		c.requiredIf = append(c.requiredIf, [2]string{kv[0], kv[1]})
+	}
+	if len(c.requiredIf) == 0 && len(c.requiredWith) == 0 && len(c.excludedWith) == 0 {
+		return nil, nil
+	}
+	return c, nil
+}
+
+func splitParams(s string) []string {
+	if s == "" {
+		return nil
+	}
+	params := strings.Split(s, ",")
+	for i := range params {
+		params[i] = strings.TrimSpace(params[i])
+	}
+	return params
+}
+
+// check the conditions of field f against the fields of its struct that were set.
+// Params are relative to the struct of the field, see NestedPrefix.
+func (c *conditions) check(s *decodeState, f *fieldInfo, fields []fieldInfo, o options) error {
+	var errs MultiError
+	name := o.key(s.prefix, f.name)
+	if s.isSet(f.name, fields, o) {
+		for _, p := range c.excludedWith {
+			if s.isSet(p, fields, o) {
+				errs.add(&ExcludedError{Field: f.sField.Name, Param: name, With: o.key(s.prefix, p)})
+			}
+		}
+		return errs.errOrNil()
+	}
+	if f.def != "" {
+		return nil
+	}
+	for _, p := range c.requiredWith {
+		if s.isSet(p, fields, o) {
+			return &RequiredError{Field: f.sField.Name, Param: name, Cond: "with " + o.key(s.prefix, p)}
+		}
+	}
+	if len(c.requiredIf) > 0 {
+		cond := make([]string, len(c.requiredIf))
+		for i, kv := range c.requiredIf {
+			p := o.key(s.prefix, kv[0])
+			if s.values.Get(p) != kv[1] {
+				return nil
+			}
+			cond[i] = p + "=" + kv[1]
+		}
+		return &RequiredError{Field: f.sField.Name, Param: name, Cond: "when " + strings.Join(cond, ",")}
+	}
+	return nil
+}
@@ -0,0 +1,277 @@
+package uri
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/jbsmith7741/trial"
+)
+
+type searchQuery struct {
+	Query   string        `uri:"q" minlen:"2" maxlen:"10"`
+	Limit   int           `uri:"limit" min:"1" max:"100" default:"10"`
+	Offset  *uint         `uri:"offset" max:"1000"`
+	Score   float64       `uri:"score" min:"0.5"`
+	Timeout time.Duration `uri:"timeout" max:"30s"`
+	Since   time.Time     `uri:"since" format:"2006-01-02" min:"2020-01-01"`
+	Sort    string        `uri:"sort" enum:"asc,desc"`
+	Pages   []int         `uri:"page" enum:"1,2,3" maxlen:"2"`
+	Tags    []string      `uri:"tag" pattern:"^[a-z]+$"`
+}
+
+func TestValidationTags(t *testing.T) {
+	fn := func(args ...interface{}) (interface{}, error) {
+		v := &searchQuery{}
+		err := Unmarshal(args[0].(string), v)
+		return v, err
+	}
+	cases := trial.Cases{
+		"valid": {
+			Input: "?q=go&limit=100&score=0.5&timeout=30s&since=2020-01-01&sort=asc&page=1,3&tag=a&tag=bc",
+			Expected: &searchQuery{
+				Query:   "go",
+				Limit:   100,
+				Score:   0.5,
+				Timeout: 30 * time.Second,
+				Since:   trial.TimeDay("2020-01-01"),
+				Sort:    "asc",
+				Pages:   []int{1, 3},
+				Tags:    []string{"a", "bc"},
+			},
+		},
+		"defaults are not validated": {
+			Input:    "?",
+			Expected: &searchQuery{Limit: 10},
+		},
+		"minlen": {
+			Input:       "?q=a",
+			ExpectedErr: errors.New(`q: "a" must have a length of at least 2`),
+		},
+		"maxlen": {
+			Input:       "?q=abcdefghijk",
+			ExpectedErr: errors.New(`q: "abcdefghijk" must have a length of at most 10`),
+		},
+		"min": {
+			Input:       "?limit=0",
+			ExpectedErr: errors.New(`limit: "0" must be at least 1`),
+		},
+		"max": {
+			Input:       "?limit=101",
+			ExpectedErr: errors.New(`limit: "101" must be at most 100`),
+		},
+		"max pointer": {
+			Input:       "?offset=1001",
+			ExpectedErr: errors.New(`offset: "1001" must be at most 1000`),
+		},
+		"min float": {
+			Input:       "?score=0.4",
+			ExpectedErr: errors.New(`score: "0.4" must be at least 0.5`),
+		},
+		"max duration": {
+			Input:       "?timeout=1m",
+			ExpectedErr: errors.New(`timeout: "1m" must be at most 30s`),
+		},
+		"min time": {
+			Input:       "?since=2019-12-31",
+			ExpectedErr: errors.New(`since: "2019-12-31" must be at least 2020-01-01`),
+		},
+		"enum": {
+			Input:       "?sort=up",
+			ExpectedErr: errors.New(`sort: "up" must be one of asc,desc`),
+		},
+		"enum elements": {
+			Input:       "?page=1,4",
+			ExpectedErr: errors.New(`page[1]: "4" must be one of 1,2,3`),
+		},
+		"slice length": {
+			Input:       "?page=1,2,3",
+			ExpectedErr: errors.New(`page: "1,2,3" must have a length of at most 2`),
+		},
+		"every rule of a field": {
+			Input: "?page=1,4,5",
+			ExpectedErr: errors.New("page: \"1,4,5\" must have a length of at most 2\n" +
+				"page[1]: \"4\" must be one of 1,2,3\npage[2]: \"5\" must be one of 1,2,3"),
+		},
+		"pattern": {
+			Input:       "?tag=a&tag=B1",
+			ExpectedErr: errors.New(`tag[1]: "B1" must match ^[a-z]+$`),
+		},
+		"multiple fields": {
+			Input:       "?limit=0&sort=up",
+			ExpectedErr: errors.New("limit: \"0\" must be at least 1\nsort: \"up\" must be one of asc,desc"),
+		},
+	}
+	trial.New(fn, cases).SubTest(t)
+}
+
+func TestValidationErrors(t *testing.T) {
+	v := &searchQuery{}
+	err := Unmarshal("?limit=500", v)
+	var fErr *FieldError
+	if !errors.As(err, &fErr) || fErr.Field != "Limit" {
+		t.Fatalf("expected FieldError for Limit got %v", err)
+	}
+	var vErr *ValidationError
+	if !errors.As(err, &vErr) || vErr.Rule != "max" || vErr.Limit != "100" {
+		t.Errorf("expected max ValidationError got %v", err)
+	}
+}
+
+func TestInvalidTags(t *testing.T) {
+	fn := func(args ...interface{}) (interface{}, error) {
+		err := Unmarshal("?a=1", args[0])
+		return nil, err
+	}
+	cases := trial.Cases{
+		"length of int": {
+			Input: &struct {
+				A int `uri:"a" maxlen:"2"`
+			}{},
+			ExpectedErr: errors.New(`invalid maxlen tag "2" on A: int does not have a length`),
+		},
+		"min of string": {
+			Input: &struct {
+				A string `uri:"a" min:"2"`
+			}{},
+			ExpectedErr: errors.New(`invalid min tag "2" on A: string is not a number, duration or time`),
+		},
+		"invalid limit": {
+			Input: &struct {
+				A int `uri:"a" max:"x"`
+			}{},
+			ExpectedErr: errors.New(`invalid max tag "x" on A`),
+		},
+		"invalid enum": {
+			Input: &struct {
+				A int `uri:"a" enum:"1,b"`
+			}{},
+			ExpectedErr: errors.New(`invalid enum tag "1,b" on A`),
+		},
+		"invalid pattern": {
+			Input: &struct {
+				A string `uri:"a" pattern:"[a-"`
+			}{},
+			ExpectedErr: errors.New(`invalid pattern tag "[a-" on A`),
+		},
+	}
+	trial.New(fn, cases).SubTest(t)
+}
+
+type eventQuery struct {
+	Start  string `uri:"start"`
+	End    string `uri:"end" required_with:"start"`
+	Page   int    `uri:"page"`
+	Cursor string `uri:"cursor" excluded_with:"page,offset"`
+	Type   string `uri:"type"`
+	Card   string `uri:"card" required_if:"type=card"`
+	Zone   string `uri:"zone" required_with:"start" default:"UTC"`
+}
+
+func TestConditionalTags(t *testing.T) {
+	fn := func(args ...interface{}) (interface{}, error) {
+		v := &eventQuery{}
+		err := Unmarshal(args[0].(string), v)
+		return v, err
+	}
+	cases := trial.Cases{
+		"none": {
+			Input:    "?",
+			Expected: &eventQuery{Zone: "UTC"},
+		},
+		"required with": {
+			Input:       "?start=1",
+			ExpectedErr: errors.New("end is required with start"),
+		},
+		"required with present": {
+			Input:    "?start=1&end=2",
+			Expected: &eventQuery{Start: "1", End: "2", Zone: "UTC"},
+		},
+		"empty values are not present": {
+			Input:    "?start=&page=1&cursor=",
+			Expected: &eventQuery{Page: 1, Zone: "UTC"},
+		},
+		"excluded with": {
+			Input:       "?cursor=abc&page=2",
+			ExpectedErr: errors.New("cursor can not be used with page"),
+		},
+		"required if": {
+			Input:       "?type=card",
+			ExpectedErr: errors.New("card is required when type=card"),
+		},
+		"required if other value": {
+			Input:    "?type=cash",
+			Expected: &eventQuery{Type: "cash", Zone: "UTC"},
+		},
+	}
+	trial.New(fn, cases).SubTest(t)
+
+	err := Unmarshal("?a=1", &struct {
+		A string `uri:"a" required_if:"b"`
+	}{})
+	if err == nil || err.Error() != `invalid required_if tag "b" on A: expected param=value got b` {
+		t.Errorf("expected invalid tag got %v", err)
+	}
+}
+
+func TestConditionalParams(t *testing.T) {
+	type items struct {
+		Start string    `uri:"start"`
+		Items []bStruct `uri:"items" required_with:"start"`
+	}
+	type ids struct {
+		Start string `uri:"start"`
+		IDs   []int  `uri:"ids" required_with:"start"`
+	}
+	type port struct {
+		Start string `uri:"start"`
+		Port  int    `uri:"port" required_with:"start"`
+	}
+	type filter struct {
+		Filter struct {
+			Status string `uri:"status" required_with:"ids"`
+			IDs    []int  `uri:"ids"`
+		} `uri:"filter"`
+	}
+	fn := func(args ...interface{}) (interface{}, error) {
+		d := NewDecoder()
+		if len(args) > 2 {
+			d = NewDecoder(args[2].(Option))
+		}
+		err := d.Unmarshal(args[0].(string), args[1])
+		return nil, err
+	}
+	brackets := Brackets(true)
+	cases := trial.Cases{
+		"indexed": {
+			Input: trial.Args("?start=1&items[0].Name=a", &items{}),
+		},
+		"indexed missing": {
+			Input:       trial.Args("?start=1", &items{}),
+			ExpectedErr: errors.New("items is required with start"),
+		},
+		"brackets": {
+			Input: trial.Args("?start=1&ids[]=1", &ids{}, brackets),
+		},
+		"brackets missing": {
+			Input:       trial.Args("?start=1&ids[]=", &ids{}, brackets),
+			ExpectedErr: errors.New("ids is required with start"),
+		},
+		"keyword": {
+			Input: trial.Args("http://h:80?start=1", &port{}),
+		},
+		"keyword missing": {
+			Input:       trial.Args("http://h?start=1", &port{}),
+			ExpectedErr: errors.New("port is required with start"),
+		},
+		"nested brackets": {
+			Input:       trial.Args("?filter[ids][]=1", &filter{}, brackets),
+			ExpectedErr: errors.New("filter[status] is required with filter[ids]"),
+		},
+		"invalid values are present": {
+			Input:       trial.Args("?start=1&ids=x", &ids{}),
+			ExpectedErr: errors.New(`ids[0]: "x" is not an int`),
+		},
+	}
+	trial.New(fn, cases).SubTest(t)
+}